@@ -0,0 +1,50 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"fmt"
+)
+
+// ansiCursorPos moves the cursor to column x of its row. Terminals other
+// than the Windows console are driven with ANSI escape sequences, which are
+// written by the ansi methods.
+func (s *State) ansiCursorPos(x int) {
+	if s.useCHA {
+		// 'G' is "Cursor Character Absolute (CHA)"
+		fmt.Fprintf(s.w, "\x1b[%dG", x+1)
+	} else {
+		// 'C' is "Cursor Forward (CUF)"
+		fmt.Fprint(s.w, "\r")
+		if x > 0 {
+			fmt.Fprintf(s.w, "\x1b[%dC", x)
+		}
+	}
+}
+
+func (s *State) ansiEraseLine() {
+	fmt.Fprint(s.w, "\x1b[0K")
+}
+
+func (s *State) ansiEraseScreen() {
+	fmt.Fprint(s.w, "\x1b[H\x1b[2J")
+}
+
+func (s *State) ansiMoveUp(lines int) {
+	fmt.Fprintf(s.w, "\x1b[%dA", lines)
+}
+
+func (s *State) ansiMoveDown(lines int) {
+	fmt.Fprintf(s.w, "\x1b[%dB", lines)
+}
+
+// setBracketedPaste turns xterm's bracketed paste mode on or off. Terminals
+// that do not support it ignore the request.
+func (s *State) setBracketedPaste(enabled bool) {
+	if enabled {
+		fmt.Fprint(s.w, "\x1b[?2004h")
+	} else {
+		fmt.Fprint(s.w, "\x1b[?2004l")
+	}
+}
@@ -0,0 +1,90 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// spinnerFrames are shown in turn below the prompt while a
+	// ContextCompleter runs
+	spinnerFrames   = `|/-\`
+	spinnerInterval = 100 * time.Millisecond
+)
+
+type completion struct {
+	head, tail string
+	list       []Candidate
+}
+
+// completeAsync calls the ContextCompleter in the background, and shows a
+// spinner below the prompt while it runs. If a key is pressed before it
+// returns, the completer's context is cancelled, its result is discarded,
+// and the key is returned as next for the prompt to handle.
+func (s *State) completeAsync(p []rune, line []rune, pos int) (c completion, next interface{}, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Buffered, so that the completer can finish after it is abandoned
+	done := make(chan completion, 1)
+	str := string(line)
+	go func() {
+		var c completion
+		c.head, c.list, c.tail = s.contextCompleter(ctx, str, pos)
+		done <- c
+	}()
+
+	ticker := time.NewTicker(spinnerInterval)
+	defer ticker.Stop()
+	for frame := 0; ; frame++ {
+		// Let other goroutines print above the prompt while waiting,
+		// as readNext does
+		if s.prompting {
+			s.outputMutex.Unlock()
+		}
+		finished := false
+		select {
+		case c = <-done:
+			finished = true
+		case <-ticker.C:
+		case <-s.ctxDone():
+		}
+		if s.prompting {
+			s.outputMutex.Lock()
+		}
+
+		switch {
+		case finished:
+			return c, nil, s.stopSpinner(p, line, pos)
+		case s.ctx != nil && s.ctx.Err() != nil:
+			return c, nil, s.ctx.Err()
+		case s.inputWaiting():
+			if err := s.stopSpinner(p, line, pos); err != nil {
+				return c, nil, err
+			}
+			next, err = s.readNext()
+			return c, next, err
+		}
+
+		text := fmt.Sprintf("%c completing…", spinnerFrames[frame%len(spinnerFrames)])
+		if !s.noStyles {
+			text = fmt.Sprintf("\x1b[%sm%s\x1b[0m", suggestStyle, text)
+		}
+		s.menu = [][]rune{[]rune(text)}
+		if err := s.refresh(p, line, pos); err != nil {
+			return c, nil, err
+		}
+	}
+}
+
+// stopSpinner erases the spinner that completeAsync shows, if it is shown.
+func (s *State) stopSpinner(p []rune, line []rune, pos int) error {
+	if s.menu == nil {
+		return nil
+	}
+	s.menu = nil
+	return s.refresh(p, line, pos)
+}
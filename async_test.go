@@ -0,0 +1,55 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContextCompleter(t *testing.T) {
+	s := newTestSession(t, 80)
+	cancelled := make(chan struct{})
+	s.SetContextCompleter(func(ctx context.Context, line string, pos int) (string, []Candidate, string) {
+		if line == "slow" {
+			<-ctx.Done()
+			close(cancelled)
+			return "", []Candidate{{Text: "stale"}}, ""
+		}
+		time.Sleep(3 * spinnerInterval)
+		return "", []Candidate{{Text: line + "ly"}}, ""
+	})
+
+	go func() {
+		io.WriteString(s.input, "quick\t")
+		time.Sleep(6 * spinnerInterval)
+		io.WriteString(s.input, "\r")
+	}()
+	line, err := s.Prompt("> ")
+	if err != nil || line != "quickly" {
+		t.Fatal("Unexpected result from Prompt", line, err)
+	}
+	if out := s.output.String(); !strings.Contains(out, "completing") {
+		t.Errorf("Expected a spinner while completing, got %q", out)
+	}
+
+	// A key pressed while the completer runs cancels it
+	go func() {
+		io.WriteString(s.input, "slow\t")
+		time.Sleep(3 * spinnerInterval)
+		io.WriteString(s.input, "er\r")
+	}()
+	line, err = s.Prompt("> ")
+	if err != nil || line != "slower" {
+		t.Fatal("Unexpected result from Prompt", line, err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("Expected the completer to be cancelled")
+	}
+}
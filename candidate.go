@@ -0,0 +1,155 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// headingStyle is the SGR style of group headings in lists of candidates
+// (bold)
+const headingStyle = "1"
+
+// display returns the text that lists of candidates show for c
+func (c Candidate) display() string {
+	if c.Display != "" {
+		return c.Display
+	}
+	return c.Text
+}
+
+// chosen returns the text that is inserted when c is chosen
+func (c Candidate) chosen() string {
+	return c.Text + c.Suffix
+}
+
+// groupCandidates returns list with the candidates of each group together,
+// with the groups in the order that they first appear in list.
+func groupCandidates(list []Candidate) []Candidate {
+	var groups []string
+	members := make(map[string][]Candidate)
+	for _, c := range list {
+		if _, ok := members[c.Group]; !ok {
+			groups = append(groups, c.Group)
+		}
+		members[c.Group] = append(members[c.Group], c)
+	}
+	grouped := make([]Candidate, 0, len(list))
+	for _, group := range groups {
+		grouped = append(grouped, members[group]...)
+	}
+	return grouped
+}
+
+// candidateGroups splits a list returned by groupCandidates into its groups
+func candidateGroups(list []Candidate) [][]Candidate {
+	var groups [][]Candidate
+	for start := 0; start < len(list); {
+		end := start + 1
+		for end < len(list) && list[end].Group == list[start].Group {
+			end++
+		}
+		groups = append(groups, list[start:end])
+		start = end
+	}
+	return groups
+}
+
+func candidateTexts(list []Candidate) []string {
+	texts := make([]string, len(list))
+	for i, c := range list {
+		texts[i] = c.Text
+	}
+	return texts
+}
+
+func candidateDisplays(list []Candidate) []string {
+	displays := make([]string, len(list))
+	for i, c := range list {
+		displays[i] = c.display()
+	}
+	return displays
+}
+
+// describes reports whether any candidate in list has a description
+func describes(list []Candidate) bool {
+	for _, c := range list {
+		if c.Description != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateWidths returns the width of the cells that list the candidates
+// in list, and the width of the display text within each cell. Candidates
+// with descriptions are listed one to a row.
+func (s *State) candidateWidths(list []Candidate) (width, displayWidth int) {
+	for _, c := range list {
+		if w := countGlyphs([]rune(c.display())); w > displayWidth {
+			displayWidth = w
+		}
+	}
+	// A space either side of each candidate
+	width = displayWidth + 2
+	if describes(list) || width > s.columns-1 {
+		width = s.columns - 1
+	}
+	if displayWidth > width-2 {
+		displayWidth = width - 2
+	}
+	return width, displayWidth
+}
+
+// candidateCell returns c as a cell, width glyphs wide, of a list of
+// candidates.
+func (s *State) candidateCell(c Candidate, width, displayWidth int, selected bool) string {
+	text := getPrefixColumns([]rune(c.display()), width-2)
+	shown := s.highlightMatches(c, text)
+	if n := countGlyphs(text); n < displayWidth {
+		text = append(text, []rune(strings.Repeat(" ", displayWidth-n))...)
+		shown += strings.Repeat(" ", displayWidth-n)
+	}
+	var desc []rune
+	if room := width - 2 - countGlyphs(text) - 2; c.Description != "" && room > 0 {
+		desc = append([]rune("  "), getPrefixColumns([]rune(c.Description), room)...)
+	}
+	pad := ""
+	if n := width - 2 - countGlyphs(text) - countGlyphs(desc); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	switch {
+	case selected && s.noStyles:
+		return fmt.Sprintf("[%s%s%s]", shown, string(desc), pad)
+	case selected:
+		return fmt.Sprintf("\x1b[%sm %s%s%s \x1b[0m", menuStyle, shown, string(desc), pad)
+	case len(desc) > 0 && !s.noStyles:
+		return fmt.Sprintf(" %s\x1b[%sm%s\x1b[0m%s ", shown, suggestStyle, string(desc), pad)
+	}
+	return fmt.Sprintf(" %s%s%s ", shown, string(desc), pad)
+}
+
+// candidateHeading returns the heading of a group of candidates
+func (s *State) candidateHeading(group string) string {
+	text := getPrefixColumns([]rune(group), s.columns-1)
+	if s.noStyles {
+		return string(text)
+	}
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", headingStyle, string(text))
+}
+
+// candidateInfo returns the rows that show the group and description of c
+// below the prompt, while c is displayed on it, or nil if c has neither.
+func (s *State) candidateInfo(c Candidate) [][]rune {
+	if c.Description == "" && c.Group == "" && c.Display == "" {
+		return nil
+	}
+	var rows [][]rune
+	if c.Group != "" {
+		rows = append(rows, []rune(s.candidateHeading(c.Group)))
+	}
+	width, displayWidth := s.candidateWidths([]Candidate{c})
+	return append(rows, []rune(s.candidateCell(c, width, displayWidth, false)))
+}
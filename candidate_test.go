@@ -0,0 +1,74 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCandidates(t *testing.T) {
+	candidates := []Candidate{
+		{Text: "--verbose", Description: "enable verbose output", Group: "Options", Suffix: " "},
+		{Text: "ls", Group: "Commands", Suffix: " "},
+		{Text: "--version", Description: "print the version", Group: "Options", Suffix: " "},
+		{Text: "docs", Display: "docs/", Group: "Files", Suffix: "/"},
+	}
+	tests := []struct {
+		style       TabStyle
+		input, line string
+		shown       []string
+	}{
+		{TabCircular, "\t\t\r", "--version ", []string{
+			"\x1b[1mOptions\x1b[0m", " --verbose\x1b[2m  enable verbose output\x1b[0m",
+		}},
+		{TabCircular, "\t\t\t\t\x1b[Z\r", "ls ", []string{"\x1b[1mFiles\x1b[0m", " docs/ "}},
+		{TabPrints, "\t\t\r", "", []string{
+			"\x1b[1mCommands\x1b[0m", " --version\x1b[2m  print the version\x1b[0m",
+		}},
+		{TabMenu, "\t\x1b[B\x1b[B\r\r", "ls ", []string{
+			"\x1b[1mOptions\x1b[0m\r\n\r\x1b[0K\x1b[7m --verbose  enable verbose output",
+		}},
+		{TabMenu, "\t\x1b[A\r\r", "docs/", []string{"\x1b[7m docs/ "}},
+	}
+	for _, test := range tests {
+		s := newTestSession(t, 60)
+		s.SetTabCompletionStyle(test.style)
+		s.SetCandidateCompleter(func(line string, pos int) (string, []Candidate, string) {
+			return "", candidates, ""
+		})
+		if line := promptWith(t, s, test.input); line != test.line {
+			t.Errorf("Input %q: expected %q, got %q", test.input, test.line, line)
+		}
+		shown := s.output.String()
+		for _, want := range test.shown {
+			if !strings.Contains(shown, want) {
+				t.Errorf("Input %q: expected %q to be shown, got %q", test.input, want, shown)
+			}
+		}
+	}
+}
+
+func TestWideCandidates(t *testing.T) {
+	candidates := []Candidate{
+		{Text: "--verbose", Description: strings.Repeat("詳細な出力", 10)},
+		{Text: strings.Repeat("日本語", 10), Description: "ワイド"},
+	}
+	for _, style := range []TabStyle{TabCircular, TabPrints, TabMenu} {
+		s := newTestSession(t, 30)
+		s.SetTabCompletionStyle(style)
+		s.SetCandidateCompleter(func(line string, pos int) (string, []Candidate, string) {
+			return "", candidates, ""
+		})
+		promptWith(t, s, "\t\x07\r")
+
+		width, displayWidth := s.candidateWidths(candidates)
+		for _, c := range candidates {
+			cell := []rune(s.candidateCell(c, width, displayWidth, false))
+			if n := countGlyphs(cell); n != width {
+				t.Errorf("Expected a cell %d columns wide, got %d in %q", width, n, string(cell))
+			}
+		}
+	}
+}
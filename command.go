@@ -0,0 +1,238 @@
+package liner
+
+import (
+	"strings"
+)
+
+// CommandSpec declares a command, such as "show users --limit N", so that
+// Liner can complete its subcommands, flags and arguments. The root of a
+// tree of commands usually has no Name, and the commands that a line can
+// begin with as its Subcommands.
+type CommandSpec struct {
+	Name        string
+	Description string
+	Subcommands []*CommandSpec
+	Flags       []*Flag
+	// Args are the positional arguments of the command, in order
+	Args []*Argument
+}
+
+// Flag declares a flag of a CommandSpec, such as "--limit".
+type Flag struct {
+	// Name is the flag as it is typed, with its dashes
+	Name        string
+	Description string
+	// Value, if not nil, is the value that follows the flag, either as the
+	// next word or after "=".
+	Value *Argument
+}
+
+// Argument declares a positional argument of a CommandSpec, or the value of a
+// Flag.
+type Argument struct {
+	// Name is shown in hints, such as "N" in "--limit N"
+	Name        string
+	Description string
+	// Values are the values that the argument is completed with
+	Values []string
+	// Provider, if not nil, returns further candidates for the argument
+	// that begin with prefix, such as the names of users
+	Provider func(prefix string) []Candidate
+	// Variadic is set on the last of the Args of a CommandSpec if it may be
+	// repeated
+	Variadic bool
+}
+
+// commandPosition is how far a line of words has reached in a tree of
+// commands
+type commandPosition struct {
+	path  []string // the names of the commands, from the root
+	cmd   *CommandSpec
+	args  int   // the number of positional arguments given
+	value *Flag // the flag whose value is the next word, if any
+}
+
+// position returns the position that words reach from c
+func (c *CommandSpec) position(words []string) commandPosition {
+	at := commandPosition{cmd: c}
+	if c.Name != "" {
+		at.path = []string{c.Name}
+	}
+	for _, word := range words {
+		if at.value != nil {
+			at.value = nil
+			continue
+		}
+		if strings.HasPrefix(word, "-") {
+			if f, hasValue := at.cmd.flag(word); f != nil {
+				if f.Value != nil && !hasValue {
+					at.value = f
+				}
+				continue
+			}
+		}
+		if at.args == 0 {
+			if sub := at.cmd.subcommand(word); sub != nil {
+				at.cmd = sub
+				at.path = append(at.path, sub.Name)
+				continue
+			}
+		}
+		at.args++
+	}
+	return at
+}
+
+// flag returns the flag of c that word names, and whether word includes its
+// value after "="
+func (c *CommandSpec) flag(word string) (*Flag, bool) {
+	name := word
+	if i := strings.Index(word, "="); i >= 0 {
+		name = word[:i]
+	}
+	for _, f := range c.Flags {
+		if f.Name == name {
+			return f, name != word
+		}
+	}
+	return nil, false
+}
+
+func (c *CommandSpec) subcommand(name string) *CommandSpec {
+	for _, sub := range c.Subcommands {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// arg returns the nth positional argument of c, or nil if it has none
+func (c *CommandSpec) arg(n int) *Argument {
+	if n < len(c.Args) {
+		return c.Args[n]
+	}
+	if len(c.Args) > 0 && c.Args[len(c.Args)-1].Variadic {
+		return c.Args[len(c.Args)-1]
+	}
+	return nil
+}
+
+// Completer returns a CandidateCompleter that completes the commands in
+// the tree that c is the root of: subcommands and positional arguments, and
+// flags once "-" is typed.
+func (c *CommandSpec) Completer() CandidateCompleter {
+	return ShellWords(func(args []string, index int, prefix string) []Candidate {
+		at := c.position(args[:index])
+		if at.value != nil {
+			return at.value.Value.candidates("", prefix)
+		}
+		if strings.HasPrefix(prefix, "-") {
+			if f, hasValue := at.cmd.flag(prefix); f != nil && hasValue && f.Value != nil {
+				name := f.Name + "="
+				return f.Value.candidates(name, strings.TrimPrefix(prefix, name))
+			}
+			var candidates []Candidate
+			for _, f := range at.cmd.Flags {
+				if strings.HasPrefix(f.Name, prefix) {
+					candidates = append(candidates, Candidate{
+						Text: f.Name, Display: f.usage(), Description: f.Description, Suffix: " ",
+					})
+				}
+			}
+			return candidates
+		}
+
+		var candidates []Candidate
+		if at.args == 0 {
+			for _, sub := range at.cmd.Subcommands {
+				if strings.HasPrefix(sub.Name, prefix) {
+					candidates = append(candidates, Candidate{
+						Text: sub.Name, Description: sub.Description, Suffix: " ",
+					})
+				}
+			}
+		}
+		if arg := at.cmd.arg(at.args); arg != nil {
+			candidates = append(candidates, arg.candidates("", prefix)...)
+		}
+		return candidates
+	})
+}
+
+// candidates returns the values of a that begin with prefix, after head
+func (a *Argument) candidates(head string, prefix string) []Candidate {
+	var candidates []Candidate
+	for _, v := range a.Values {
+		if strings.HasPrefix(v, prefix) {
+			candidates = append(candidates, Candidate{Text: head + v, Display: v, Suffix: " "})
+		}
+	}
+	if a.Provider != nil {
+		for _, c := range a.Provider(prefix) {
+			if c.Display == "" {
+				c.Display = c.Text
+			}
+			c.Text = head + c.Text
+			candidates = append(candidates, c)
+		}
+	}
+	for i := range candidates {
+		if candidates[i].Display == candidates[i].Text {
+			candidates[i].Display = ""
+		}
+	}
+	return candidates
+}
+
+// Hint returns a hint for the word under the cursor in line, as a function
+// for SetStatusLine: the usage of the command that is being typed, such as
+// "show users [--limit N] <name>", or the description of the value of a
+// flag when one is expected.
+func (c *CommandSpec) Hint(line string, pos int) string {
+	runes := []rune(line)
+	if pos < 0 || pos > len(runes) {
+		return ""
+	}
+	var words []string
+	for _, w := range shellSplit(runes) {
+		if w.end >= pos {
+			break
+		}
+		words = append(words, shellUnquote(runes[w.start:w.end]))
+	}
+	at := c.position(words)
+	if at.value != nil {
+		hint := at.value.usage()
+		if desc := at.value.Value.Description; desc != "" {
+			hint += ": " + desc
+		}
+		return hint
+	}
+	if len(at.path) == 0 {
+		return ""
+	}
+
+	usage := strings.Join(at.path, " ")
+	for _, f := range at.cmd.Flags {
+		usage += " [" + f.usage() + "]"
+	}
+	for _, a := range at.cmd.Args {
+		usage += " <" + a.Name + ">"
+		if a.Variadic {
+			usage += "..."
+		}
+	}
+	if len(at.cmd.Subcommands) > 0 && at.args == 0 {
+		usage += " <command>"
+	}
+	return usage
+}
+
+// usage returns the flag as it is shown in hints, such as "--limit N"
+func (f *Flag) usage() string {
+	if f.Value == nil {
+		return f.Name
+	}
+	return f.Name + " " + f.Value.Name
+}
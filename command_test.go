@@ -0,0 +1,96 @@
+package liner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testCommands() *CommandSpec {
+	users := &Argument{Name: "name", Provider: func(prefix string) []Candidate {
+		var c []Candidate
+		for _, name := range []string{"alice", "bob"} {
+			if strings.HasPrefix(name, prefix) {
+				c = append(c, Candidate{Text: name, Suffix: " "})
+			}
+		}
+		return c
+	}, Variadic: true}
+	limit := &Flag{Name: "--limit", Description: "most rows to show",
+		Value: &Argument{Name: "N", Description: "a number of rows", Values: []string{"10", "100"}}}
+	return &CommandSpec{Subcommands: []*CommandSpec{
+		{Name: "show", Description: "show things", Subcommands: []*CommandSpec{
+			{Name: "users", Description: "list users", Flags: []*Flag{
+				limit, {Name: "--all", Description: "include disabled users"},
+			}, Args: []*Argument{users}},
+			{Name: "tables", Description: "list tables"},
+		}},
+		{Name: "set", Args: []*Argument{
+			{Name: "option", Values: []string{"echo", "timing"}},
+			{Name: "value", Values: []string{"on", "off"}},
+		}},
+	}}
+}
+
+func TestCommandCompleter(t *testing.T) {
+	complete := testCommands().Completer()
+	tests := []struct {
+		line  string
+		head  string
+		texts []string
+	}{
+		{"", "", []string{"show", "set"}},
+		{"s", "", []string{"show", "set"}},
+		{"show ", "show ", []string{"users", "tables"}},
+		{"show u", "show ", []string{"users"}},
+		{"show users ", "show users ", []string{"alice", "bob"}},
+		{"show users a", "show users ", []string{"alice"}},
+		{"show users alice ", "show users alice ", []string{"alice", "bob"}},
+		{"show users -", "show users ", []string{"--limit", "--all"}},
+		{"show users --l", "show users ", []string{"--limit"}},
+		{"show users --limit ", "show users --limit ", []string{"10", "100"}},
+		{"show users --limit 10 b", "show users --limit 10 ", []string{"bob"}},
+		{"show users --limit=1", "show users ", []string{"--limit=10", "--limit=100"}},
+		{"show users --all a", "show users --all ", []string{"alice"}},
+		{"show tables ", "show tables ", nil},
+		{"set ", "set ", []string{"echo", "timing"}},
+		{"set echo o", "set echo ", []string{"on", "off"}},
+		{"set echo on ", "set echo on ", nil},
+	}
+	for _, test := range tests {
+		head, candidates, _ := complete(test.line, len(test.line))
+		var texts []string
+		for _, c := range candidates {
+			texts = append(texts, c.Text)
+		}
+		if head != test.head || !reflect.DeepEqual(texts, test.texts) {
+			t.Errorf("Completing %q: expected %q, %q, got %q, %q", test.line, test.head, test.texts, head, texts)
+		}
+	}
+
+	_, candidates, _ := complete("show users --l", 14)
+	want := Candidate{Text: "--limit", Display: "--limit N", Description: "most rows to show", Suffix: " "}
+	if len(candidates) != 1 || candidates[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, candidates)
+	}
+}
+
+func TestCommandHint(t *testing.T) {
+	root := testCommands()
+	tests := []struct {
+		line, hint string
+	}{
+		{"", ""},
+		{"show", ""},
+		{"show ", "show <command>"},
+		{"show users ", "show users [--limit N] [--all] <name>..."},
+		{"show users --limit ", "--limit N: a number of rows"},
+		{"show users --limit 5 al", "show users [--limit N] [--all] <name>..."},
+		{"set ", "set <option> <value>"},
+	}
+	for _, test := range tests {
+		if hint := root.Hint(test.line, len(test.line)); hint != test.hint {
+			t.Errorf("Hint for %q: expected %q, got %q", test.line, test.hint, hint)
+		}
+	}
+}
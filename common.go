@@ -7,39 +7,105 @@ package liner
 
 import (
 	"bufio"
+	"bytes"
 	"container/ring"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
-	"unicode/utf8"
+	"time"
 )
 
 type commonState struct {
-	terminalSupported bool
-	outputRedirected  bool
-	inputRedirected   bool
-	history           []string
-	historyMutex      sync.RWMutex
-	completer         WordCompleter
-	columns           int
-	killRing          *ring.Ring
-	ctrlCAborts       bool
-	r                 *bufio.Reader
-	tabStyle          TabStyle
-	multiLineMode     bool
-	cursorRows        int
-	maxRows           int
-	shouldRestart     ShouldRestart
-	noBeep            bool
-	needRefresh       bool
+	terminalSupported  bool
+	outputRedirected   bool
+	inputRedirected    bool
+	history            HistoryStore
+	historyMutex       sync.RWMutex
+	historyErrors      func(err error)
+	completer          CandidateCompleter
+	contextCompleter   ContextCompleter
+	matching           Matching
+	matched            map[string][]int // the runes of each candidate's Text that matched, while completing
+	columns            int
+	killRing           *ring.Ring
+	ctrlCAborts        bool
+	r                  *bufio.Reader
+	w                  io.Writer
+	columnsFunc        func() int
+	session            bool
+	ctx                context.Context
+	outputMutex        sync.Mutex
+	prompting          bool
+	shownPrompt        []rune
+	shownLine          []rune
+	shownPos           int
+	keymap             map[Key]Command
+	editMode           EditMode
+	viInsertIndicator  string
+	viNormalIndicator  string
+	sessionColumns     int32
+	tabStyle           TabStyle
+	multiLineMode      bool
+	pasteNewlines      bool
+	continuationPrompt string
+	isComplete         IsComplete
+	rightPromptFunc    func() string
+	rightPrompt        []rune
+	transientPrompt    func(line string) string
+	statusFunc         func(line string, pos int) string
+	belowRows          int // rows displayed below the prompt
+	menu               [][]rune
+	cursorCol          int
+	noStyles           bool // the output cannot display SGR sequences
+	useCHA             bool // the terminal supports "Cursor Character Absolute"
+	autoSuggest        bool
+	suggester          Suggester
+	suggestion         string
+	suggestedFor       string
+	suggested          bool
+	highlighter        Highlighter
+	cursorRows         int
+	maxRows            int
+	shouldRestart      ShouldRestart
+	noBeep             bool
+	needRefresh        bool
+}
+
+// Options configures a State created by NewLinerWithOptions. The zero value
+// selects the process's standard input and output, exactly like NewLiner.
+type Options struct {
+	// Input is read for keystrokes. If nil, Terminal is used if it is set,
+	// and os.Stdin otherwise.
+	Input io.Reader
+	// Output receives the prompt, the edited line and every terminal
+	// control sequence. If nil, Terminal is used if it is set, and
+	// os.Stdout otherwise.
+	Output io.Writer
+	// Terminal is the terminal device attached to Input and Output. Its
+	// mode is switched to raw mode and its width is queried. If nil, Input
+	// and Output are used directly when they are *os.File values, and
+	// are treated as redirected otherwise.
+	//
+	// On Windows, the console is always reached through Input and Output,
+	// and Terminal is ignored.
+	Terminal *os.File
+	// Columns, if non-nil, is called to find the width of the output in
+	// place of querying the terminal. Setting Columns also declares that
+	// Output is a terminal.
+	Columns func() int
 }
 
 // TabStyle is used to select how tab completions are displayed.
 type TabStyle int
 
-// Two tab styles are currently available:
+// Three tab styles are currently available:
 //
 // TabCircular cycles through each completion item and displays it directly on
 // the prompt
@@ -47,9 +113,16 @@ type TabStyle int
 // TabPrints prints the list of completion items to the screen after a second
 // tab key is pressed. This behaves similar to GNU readline and BASH (which
 // uses readline)
+//
+// TabMenu draws the completion items in a menu below the prompt, where the
+// selected item is highlighted and displayed on the prompt. Tab, Shift-Tab
+// and the arrow keys move through the menu, Page Up and Page Down move
+// through long menus a page at a time, Enter accepts the selected item and
+// Esc cancels the completion.
 const (
 	TabCircular TabStyle = iota
 	TabPrints
+	TabMenu
 )
 
 // ErrPromptAborted is returned from Prompt or PasswordPrompt when the user presses Ctrl-C
@@ -61,8 +134,10 @@ var ErrPromptAborted = errors.New("prompt aborted")
 var ErrNotTerminalOutput = errors.New("standard output is not a terminal")
 
 // ErrInvalidPrompt is returned from Prompt or PasswordPrompt if the
-// prompt contains any unprintable runes (including substrings that could
-// be colour codes on some platforms).
+// prompt (or the continuation prompt) contains any unprintable runes other
+// than newlines, which start another row of the prompt. SGR escape sequences, which set
+// the colour and style of text (such as "\x1b[1;32m"), are allowed; they are
+// left out on terminals that cannot display them.
 var ErrInvalidPrompt = errors.New("invalid prompt")
 
 // ErrInternal is returned when liner experiences an error that it cannot
@@ -76,39 +151,176 @@ const KillRingMax = 60
 // HistoryLimit is the maximum number of entries saved in the scrollback history.
 const HistoryLimit = 1000
 
-// ReadHistory reads scrollback history from r. Returns the number of lines
-// read, and any read error (except io.EOF).
-func (s *State) ReadHistory(r io.Reader) (num int, err error) {
-	s.historyMutex.Lock()
-	defer s.historyMutex.Unlock()
+// HistoryEntry is an entry of the scrollback history, with details of when
+// and where it was entered. Entries added by AppendHistory, or read from
+// plain history files, have only a Line.
+type HistoryEntry struct {
+	Line string
+	// Time is when the entry was entered
+	Time time.Time
+	// Dir is the working directory that the entry was entered in
+	Dir string
+	// ExitStatus is the exit status of the command, if the application
+	// records it (see SetLastHistoryStatus), or 0
+	ExitStatus int
+	// Session identifies the session that the entry was entered in
+	Session string
+	// Meta holds any other details that the application records
+	Meta map[string]string
+}
+
+// hasDetails reports whether e has any details other than its Line
+func (e HistoryEntry) hasDetails() bool {
+	return !e.Time.IsZero() || e.Dir != "" || e.ExitStatus != 0 || e.Session != "" || len(e.Meta) > 0
+}
+
+// historyHeader is the first line of history files that hold details, so
+// that the "#" lines of plain history files are still read as entries
+const historyHeader = "#liner-history v2"
+
+// extended reports whether e can only be written to a history file that
+// starts with historyHeader: if it has details, spans several lines, or
+// would be read as a details line or the header.
+func (e HistoryEntry) extended() bool {
+	_, _, looksLike := parseDetails(e.Line)
+	return e.hasDetails() || looksLike || e.Line == historyHeader || strings.Contains(e.Line, "\n")
+}
+
+// The details of a history entry are written on the line before it, in the
+// form "#<unix time> dir=<dir> status=<status> session=<session> +<key>=<value>",
+// with the names and values escaped as in URL queries. Bash writes the
+// time of entries in the same way. An entry of several lines is preceded
+// by "lines=<n>" among the details.
+func (e HistoryEntry) detailsLine() string {
+	return e.details(strings.Count(e.Line, "\n") + 1)
+}
+
+// updateLine returns a line that replaces the details of the entry before
+// it with those of e. It is a details line of an entry of no lines.
+func (e HistoryEntry) updateLine() string {
+	return e.details(0)
+}
+
+// details returns the details line of e, for an entry of the given number
+// of lines
+func (e HistoryEntry) details(lines int) string {
+	var b strings.Builder
+	b.WriteString("#")
+	if !e.Time.IsZero() {
+		b.WriteString(strconv.FormatInt(e.Time.Unix(), 10))
+	} else {
+		b.WriteString("0")
+	}
+	field := func(key, value string) {
+		b.WriteString(" " + key + "=" + url.QueryEscape(value))
+	}
+	if lines != 1 {
+		field("lines", strconv.Itoa(lines))
+	}
+	if e.Dir != "" {
+		field("dir", e.Dir)
+	}
+	if e.ExitStatus != 0 {
+		field("status", strconv.Itoa(e.ExitStatus))
+	}
+	if e.Session != "" {
+		field("session", e.Session)
+	}
+	keys := make([]string, 0, len(e.Meta))
+	for key := range e.Meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field("+"+url.QueryEscape(key), e.Meta[key])
+	}
+	return b.String()
+}
 
-	in := bufio.NewReader(r)
-	num = 0
-	for {
-		line, part, err := in.ReadLine()
-		if err == io.EOF {
-			break
+// parseDetails returns the details that line, written by detailsLine or
+// updateLine, holds, and the number of lines of the entry that follows (0
+// for an update), or false if it is not such a line.
+func parseDetails(line string) (e HistoryEntry, lines int, ok bool) {
+	lines = 1
+	if !strings.HasPrefix(line, "#") {
+		return e, lines, false
+	}
+	fields := strings.Split(line[1:], " ")
+	secs, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || secs < 0 {
+		return e, lines, false
+	}
+	if secs > 0 {
+		e.Time = time.Unix(secs, 0)
+	}
+	for _, field := range fields[1:] {
+		i := strings.Index(field, "=")
+		if i < 0 {
+			return e, lines, false
 		}
+		key, value := field[:i], field[i+1:]
+		value, err := url.QueryUnescape(value)
 		if err != nil {
-			return num, err
-		}
-		if part {
-			return num, fmt.Errorf("line %d is too long", num+1)
-		}
-		if !utf8.Valid(line) {
-			return num, fmt.Errorf("invalid string at line %d", num+1)
+			return e, lines, false
 		}
-		num++
-		s.history = append(s.history, string(line))
-		if len(s.history) > HistoryLimit {
-			s.history = s.history[1:]
+		switch {
+		case key == "lines":
+			if lines, err = strconv.Atoi(value); err != nil || lines < 0 {
+				return e, 1, false
+			}
+		case key == "dir":
+			e.Dir = value
+		case key == "status":
+			if e.ExitStatus, err = strconv.Atoi(value); err != nil {
+				return e, lines, false
+			}
+		case key == "session":
+			e.Session = value
+		case strings.HasPrefix(key, "+"):
+			if key, err = url.QueryUnescape(key[1:]); err != nil {
+				return e, lines, false
+			}
+			if e.Meta == nil {
+				e.Meta = make(map[string]string)
+			}
+			e.Meta[key] = value
+		default:
+			return e, lines, false
 		}
 	}
-	return num, nil
+	return e, lines, true
+}
+
+// ReadHistory reads scrollback history from r. Returns the number of lines
+// read, and any read error (except io.EOF). The details of entries that
+// WriteHistory writes are read back, and plain files of one entry per line
+// are read as before.
+func (s *State) ReadHistory(r io.Reader) (num int, err error) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	store := s.historyStore()
+	batcher, ok := store.(historyBatcher)
+	if !ok {
+		num, _, err = readHistory(r, store.Append)
+		return num, err
+	}
+	var entries []HistoryEntry
+	num, _, err = readHistory(r, func(entry HistoryEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if berr := batcher.AppendEntries(entries); err == nil {
+		err = berr
+	}
+	return num, err
 }
 
 // WriteHistory writes scrollback history to w. Returns the number of lines
-// successfully written, and any write error.
+// successfully written, and any write error. If any entry has details, such
+// as those added by AppendHistoryEntry, or several lines, the file starts
+// with a header line, and each such entry is preceded by a line that holds
+// its details. Otherwise, one entry is written per line, as before.
 //
 // Unlike the rest of liner's API, WriteHistory is safe to call
 // from another goroutine while Prompt is in progress.
@@ -118,45 +330,187 @@ func (s *State) WriteHistory(w io.Writer) (num int, err error) {
 	s.historyMutex.RLock()
 	defer s.historyMutex.RUnlock()
 
-	for _, item := range s.history {
-		_, err := fmt.Fprintln(w, item)
-		if err != nil {
-			return num, err
-		}
-		num++
+	if s.history == nil {
+		return 0, nil
+	}
+	var entries []HistoryEntry
+	if err := s.history.Iterate(true, func(item HistoryEntry) bool {
+		entries = append(entries, item)
+		return true
+	}); err != nil {
+		return 0, err
+	}
+	return writeHistory(w, entries)
+}
+
+// SetHistoryStore replaces the store that holds the scrollback history,
+// such as with a HistoryFile, so that AppendHistory, ReadHistory, the
+// history commands of Prompt and the rest of the history API use it. The
+// previous store is returned, and is not closed. A nil store restores an
+// empty MemoryHistory.
+func (s *State) SetHistoryStore(store HistoryStore) HistoryStore {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	old := s.history
+	s.history = store
+	return old
+}
+
+// SetHistoryErrorHandler sets a function that is called with the errors
+// that the store of the scrollback history returns when AppendHistory,
+// AppendHistoryEntry, SetLastHistoryStatus or ClearHistory change it, such
+// as a failure to write a HistoryFile. Such errors are ignored by default.
+// f is called with the history lock held, so it must not call the history
+// methods of the State.
+func (s *State) SetHistoryErrorHandler(f func(err error)) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+	s.historyErrors = f
+}
+
+// historyError reports err, if any, to the function set by
+// SetHistoryErrorHandler
+func (s *State) historyError(err error) {
+	if err != nil && s.historyErrors != nil {
+		s.historyErrors(err)
 	}
-	return num, nil
+}
+
+// historyStore returns the store of the scrollback history, and creates
+// the default one if there is none yet. It is called with historyMutex
+// held for writing.
+func (s *State) historyStore() HistoryStore {
+	if s.history == nil {
+		s.history = &MemoryHistory{}
+	}
+	return s.history
+}
+
+// closeHistory closes the store of the scrollback history
+func (s *State) closeHistory() error {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	if s.history == nil {
+		return nil
+	}
+	return s.history.Close()
+}
+
+// lastHistory returns the newest entry of the scrollback history, if any
+func (s *State) lastHistory() (last HistoryEntry, ok bool) {
+	if s.history == nil {
+		return last, false
+	}
+	s.history.Iterate(false, func(entry HistoryEntry) bool {
+		last, ok = entry, true
+		return false
+	})
+	return last, ok
 }
 
 // AppendHistory appends an entry to the scrollback history. AppendHistory
 // should be called iff Prompt returns a valid command.
 func (s *State) AppendHistory(item string) {
+	s.appendHistory(HistoryEntry{Line: item})
+}
+
+// AppendHistoryEntry appends an entry, with its details, to the scrollback
+// history, in place of AppendHistory. If the entry has no Time, the current
+// time is recorded.
+func (s *State) AppendHistoryEntry(entry HistoryEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	s.appendHistory(entry)
+}
+
+func (s *State) appendHistory(entry HistoryEntry) {
 	s.historyMutex.Lock()
 	defer s.historyMutex.Unlock()
 
-	if len(s.history) > 0 {
-		if item == s.history[len(s.history)-1] {
-			return
-		}
+	if last, ok := s.lastHistory(); ok && entry.Line == last.Line {
+		return
 	}
-	s.history = append(s.history, item)
-	if len(s.history) > HistoryLimit {
-		s.history = s.history[1:]
+	s.historyError(s.historyStore().Append(entry))
+}
+
+// SetLastHistoryStatus records the exit status of the command of the last
+// entry in the scrollback history, once it has run. It has no effect if
+// the store of the history cannot update its entries.
+func (s *State) SetLastHistoryStatus(status int) {
+	s.historyMutex.Lock()
+	defer s.historyMutex.Unlock()
+
+	if u, ok := s.history.(historyUpdater); ok {
+		s.historyError(u.UpdateLast(func(entry *HistoryEntry) {
+			entry.ExitStatus = status
+		}))
 	}
 }
 
-// ClearHistory clears the scrollback history.
+// HistoryEntries returns the entries of the scrollback history, oldest
+// first, for which match returns true, or every entry if match is nil.
+func (s *State) HistoryEntries(match func(entry HistoryEntry) bool) []HistoryEntry {
+	s.historyMutex.RLock()
+	defer s.historyMutex.RUnlock()
+
+	var entries []HistoryEntry
+	if s.history == nil {
+		return entries
+	}
+	s.history.Iterate(true, func(entry HistoryEntry) bool {
+		if match == nil || match(entry) {
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	return entries
+}
+
+// HistoryBetween returns the entries of the scrollback history that were
+// entered at or after from and before to.
+func (s *State) HistoryBetween(from, to time.Time) []HistoryEntry {
+	return s.HistoryEntries(func(entry HistoryEntry) bool {
+		return !entry.Time.Before(from) && entry.Time.Before(to)
+	})
+}
+
+// HistoryWithMeta returns the entries of the scrollback history whose Meta
+// holds value for key.
+func (s *State) HistoryWithMeta(key, value string) []HistoryEntry {
+	return s.HistoryEntries(func(entry HistoryEntry) bool {
+		v, ok := entry.Meta[key]
+		return ok && v == value
+	})
+}
+
+// ClearHistory clears the scrollback history. A store that cannot remove
+// its entries is closed, and replaced with an empty MemoryHistory.
 func (s *State) ClearHistory() {
 	s.historyMutex.Lock()
 	defer s.historyMutex.Unlock()
+
+	if c, ok := s.history.(historyClearer); ok {
+		s.historyError(c.Clear())
+		return
+	}
+	if s.history != nil {
+		s.historyError(s.history.Close())
+	}
 	s.history = nil
 }
 
 // Returns the history lines starting with prefix
 func (s *State) getHistoryByPrefix(prefix string) (ph []string) {
-	for _, h := range s.history {
-		if strings.HasPrefix(h, prefix) {
-			ph = append(ph, h)
+	if s.history == nil {
+		return
+	}
+	found, _ := s.history.Search(prefix)
+	for _, h := range found {
+		if strings.HasPrefix(h.Line, prefix) {
+			ph = append(ph, h.Line)
 		}
 	}
 	return
@@ -164,12 +518,13 @@ func (s *State) getHistoryByPrefix(prefix string) (ph []string) {
 
 // Returns the history lines matching the intelligent search
 func (s *State) getHistoryByPattern(pattern string) (ph []string, pos []int) {
-	if pattern == "" {
+	if pattern == "" || s.history == nil {
 		return
 	}
-	for _, h := range s.history {
-		if i := strings.Index(h, pattern); i >= 0 {
-			ph = append(ph, h)
+	found, _ := s.history.Search(pattern)
+	for _, h := range found {
+		if i := strings.Index(h.Line, pattern); i >= 0 {
+			ph = append(ph, h.Line)
 			pos = append(pos, i)
 		}
 	}
@@ -191,30 +546,195 @@ type WordCompleter func(line string, pos int) (head string, completions []string
 // SetCompleter sets the completion function that Liner will call to
 // fetch completion candidates when the user presses tab.
 func (s *State) SetCompleter(f Completer) {
+	s.contextCompleter = nil
 	if f == nil {
 		s.completer = nil
 		return
 	}
-	s.completer = func(line string, pos int) (string, []string, string) {
-		return "", f(string([]rune(line)[:pos])), string([]rune(line)[pos:])
+	s.completer = func(line string, pos int) (string, []Candidate, string) {
+		return "", plainCandidates(f(string([]rune(line)[:pos]))), string([]rune(line)[pos:])
 	}
 }
 
 // SetWordCompleter sets the completion function that Liner will call to
 // fetch completion candidates when the user presses tab.
 func (s *State) SetWordCompleter(f WordCompleter) {
+	s.contextCompleter = nil
+	if f == nil {
+		s.completer = nil
+		return
+	}
+	s.completer = func(line string, pos int) (string, []Candidate, string) {
+		head, completions, tail := f(line, pos)
+		return head, plainCandidates(completions), tail
+	}
+}
+
+// Candidate is a completion candidate, with details of how it is displayed
+// in lists of candidates.
+type Candidate struct {
+	// Text replaces the partial word when the candidate is chosen
+	Text string
+	// Display, if not empty, is shown in lists in place of Text
+	Display string
+	// Description, if not empty, is shown next to the candidate in lists,
+	// such as "enable verbose output" for "--verbose"
+	Description string
+	// Group, if not empty, is the heading that the candidate is listed
+	// under, such as "Files" or "Commands"
+	Group string
+	// Suffix is inserted after Text when the candidate is chosen, such as
+	// "/" after a directory name or " " after a whole word
+	Suffix string
+}
+
+// CandidateCompleter is like WordCompleter, but returns Candidates so that
+// lists of completions can show descriptions and groups.
+type CandidateCompleter func(line string, pos int) (head string, candidates []Candidate, tail string)
+
+// SetCandidateCompleter sets the completion function that Liner will call
+// to fetch completion candidates when the user presses tab.
+func (s *State) SetCandidateCompleter(f CandidateCompleter) {
+	s.contextCompleter = nil
 	s.completer = f
 }
 
+// Matching selects how Liner filters the completion candidates returned by
+// the completer against the partial word being completed, which is the text
+// between the completer's head and the cursor.
+type Matching int
+
+// Three kinds of matching are currently available:
+//
+// MatchNone is the default, and leaves the filtering of candidates to the
+// completer
+//
+// MatchSubstring keeps the candidates that contain the word, ignoring case,
+// and lists them in the same order as MatchFuzzy
+//
+// MatchFuzzy keeps the candidates that contain the runes of the word in
+// order, ignoring case, and lists the closest matches first. Runes that are
+// next to each other or that start words in the candidate match best.
+const (
+	MatchNone Matching = iota
+	MatchSubstring
+	MatchFuzzy
+)
+
+// SetCompletionMatching sets how completion candidates are filtered and
+// ranked before they are displayed. With MatchSubstring or MatchFuzzy, the
+// matched runes are highlighted in lists of candidates.
+func (s *State) SetCompletionMatching(m Matching) {
+	s.matching = m
+}
+
+// ContextCompleter is like CandidateCompleter, but may be slow. Liner calls
+// it in the background, and shows a spinner below the prompt while it runs.
+// If the user presses a key before it returns, ctx is cancelled, its result
+// is discarded, and the key is handled as usual.
+type ContextCompleter func(ctx context.Context, line string, pos int) (head string, candidates []Candidate, tail string)
+
+// SetContextCompleter sets the completion function that Liner will call
+// in the background to fetch completion candidates when the user presses
+// tab.
+func (s *State) SetContextCompleter(f ContextCompleter) {
+	s.completer = nil
+	s.contextCompleter = f
+}
+
+func plainCandidates(completions []string) []Candidate {
+	if completions == nil {
+		return nil
+	}
+	candidates := make([]Candidate, len(completions))
+	for i, c := range completions {
+		candidates[i].Text = c
+	}
+	return candidates
+}
+
+// Suggester takes the currently edited line and returns a suggested line
+// that begins with it, or "" if there is no suggestion.
+type Suggester func(line string) string
+
+// SetAutoSuggest sets whether the most recent history entry that begins with
+// the edited line is suggested, fish-style. The rest of the suggested line is
+// shown dimmed after the cursor, while the cursor is at the end of the line.
+// Right, End, Ctrl-F or Ctrl-E accept the whole suggestion, and Alt-F accepts
+// its next word. The default is false (no suggestions).
+//
+// Suggestions are not shown on terminals that cannot display dimmed text.
+func (s *State) SetAutoSuggest(enabled bool) {
+	s.autoSuggest = enabled
+}
+
+// SetSuggester sets the function that Liner will call to fetch a suggestion
+// for the edited line, in place of the history. A non-nil f turns
+// suggestions on, regardless of SetAutoSuggest.
+func (s *State) SetSuggester(f Suggester) {
+	s.suggester = f
+}
+
+// Span is a part of the edited line, from byte offset Start up to End, that
+// is displayed in Style. Style holds SGR (Select Graphic Rendition)
+// parameters, such as "1" for bold, "31" for red, or "1;31" for both.
+type Span struct {
+	Start, End int
+	Style      string
+}
+
+// Highlighter takes the currently edited line and returns the spans of it
+// to display in colour. Where spans overlap, the later span's style is used;
+// the rest of the line is displayed unstyled.
+type Highlighter func(line string) []Span
+
+// SetHighlighter sets the function that Liner will call to highlight the
+// edited line each time it is displayed. Highlighting has no effect on
+// terminals that cannot display styled text.
+func (s *State) SetHighlighter(f Highlighter) {
+	s.highlighter = f
+}
+
 // SetTabCompletionStyle sets the behvavior when the Tab key is pressed
 // for auto-completion.  TabCircular is the default behavior and cycles
 // through the list of candidates at the prompt.  TabPrints will print
 // the available completion candidates to the screen similar to BASH
-// and GNU Readline
+// and GNU Readline.  TabMenu draws them in a menu below the prompt
 func (s *State) SetTabCompletionStyle(tabStyle TabStyle) {
 	s.tabStyle = tabStyle
 }
 
+// EditMode selects the style of key bindings used by Prompt.
+type EditMode int
+
+// Two edit modes are currently available:
+//
+// EmacsMode is the default, and uses the key bindings listed in the README.
+//
+// ViMode starts each prompt in vi insert mode, where the EmacsMode bindings
+// apply. Esc switches to vi normal mode, which supports the motions
+// h l w b e W B E 0 ^ $ f F t T ; and , (with counts), the operators d c
+// and y (with motions, and as dd cc yy), D C Y x X s S r ~ p P and u, and
+// j and k to move through history. i a I A, c and s return to insert mode.
+const (
+	EmacsMode EditMode = iota
+	ViMode
+)
+
+// SetEditMode sets the style of key bindings used by Prompt. EmacsMode is
+// the default.
+func (s *State) SetEditMode(mode EditMode) {
+	s.editMode = mode
+}
+
+// SetViModeIndicator sets text to show before the prompt in ViMode, to tell
+// the user whether insert mode or normal mode is active. The default is to
+// show nothing.
+func (s *State) SetViModeIndicator(insert, normal string) {
+	s.viInsertIndicator = insert
+	s.viNormalIndicator = normal
+}
+
 // ModeApplier is the interface that wraps a representation of the terminal
 // mode. ApplyMode sets the terminal to this mode.
 type ModeApplier interface {
@@ -234,6 +754,78 @@ func (s *State) SetMultiLineMode(mlmode bool) {
 	s.multiLineMode = mlmode
 }
 
+// SetRightPrompt sets a function that returns text to display at the right
+// of the row that is being edited, like zsh's RPROMPT; for example, the
+// current git branch or the time. f is called each time the prompt is
+// displayed. The text is hidden while the line (or a suggestion) would
+// reach it, and whenever it contains unprintable runes other than SGR
+// escape sequences.
+func (s *State) SetRightPrompt(f func() string) {
+	s.rightPromptFunc = f
+}
+
+// SetTransientPrompt sets a function that Liner will call when Enter ends a
+// prompt. The prompt and line are erased and replaced with the text that f
+// returns, such as "$ " followed by the line, so that a long prompt does not
+// fill up the scrollback. The text may contain newlines and SGR escape
+// sequences.
+func (s *State) SetTransientPrompt(f func(line string) string) {
+	s.transientPrompt = f
+}
+
+// SetStatusLine sets a function that returns a line of text to display
+// below the edited line, such as an error message or a hint about the
+// arguments of the command being typed. f is called with the line and the
+// cursor position (in runes) each time the line is displayed, and the status
+// line is removed when the prompt ends. An empty string displays no status
+// line. The text is cut short at its first newline and at the width of the
+// terminal, and may contain SGR escape sequences.
+func (s *State) SetStatusLine(f func(line string, pos int) string) {
+	s.statusFunc = f
+}
+
+// IsComplete takes the text entered so far and reports whether it is
+// complete, such as a whole SQL statement or a balanced Lisp expression.
+type IsComplete func(text string) bool
+
+// SetIsComplete sets the function that Liner will call when Enter is pressed.
+// If f returns false, Enter starts a new line of the text instead of ending
+// the prompt, so that Prompt returns text that spans several lines. Up and
+// Down then move between the lines of the text (before moving through the
+// history), and Home and End move to the start and end of the current line.
+//
+// Text with more than one line is edited in multi-line mode, so a non-nil f
+// turns multi-line mode on.
+func (s *State) SetIsComplete(f IsComplete) {
+	s.isComplete = f
+	if f != nil {
+		s.multiLineMode = true
+	}
+}
+
+// SetContinuationPrompt sets the prompt that is displayed at the start of
+// each row that follows a newline in the edited line, in multi-line mode.
+// The default is "" (no continuation prompt). Like the prompt passed to
+// Prompt, it may be styled with SGR escape sequences.
+func (s *State) SetContinuationPrompt(prompt string) {
+	s.continuationPrompt = prompt
+}
+
+// SetPasteNewlines sets whether the line breaks in pasted text are kept, so
+// that Prompt can return a line that contains newlines. They are only kept
+// in multi-line mode; otherwise, or if keep is false (the default), each line
+// break is replaced by a space.
+//
+// The rest of the pasted text is inserted as it is, except that each tab is
+// replaced by a space, since the edited line cannot display tabs, and other
+// control characters are dropped.
+//
+// Pasted text is only recognised on terminals that support xterm's
+// bracketed paste mode. Elsewhere it is read as if it was typed.
+func (s *State) SetPasteNewlines(keep bool) {
+	s.pasteNewlines = keep
+}
+
 // ShouldRestart is passed the error generated by readNext and returns true if
 // the the read should be restarted or false if the error should be returned.
 type ShouldRestart func(err error) bool
@@ -250,13 +842,87 @@ func (s *State) SetBeep(beep bool) {
 	s.noBeep = !beep
 }
 
+// LineEditing reports whether Prompt edits lines, with the history keys,
+// completion and the rest of liner's editing. It does not if the input or
+// output is not a terminal, or if the terminal is not supported.
+func (s *State) LineEditing() bool {
+	return s.terminalSupported && !s.inputRedirected && !s.outputRedirected
+}
+
+// ctxDone returns the channel that is closed when the context of the
+// active PromptContext is done, or nil if there is no such context.
+func (s *commonState) ctxDone() <-chan struct{} {
+	if s.ctx == nil {
+		return nil
+	}
+	return s.ctx.Done()
+}
+
+// cancelled reports whether err is the result of cancelling the context
+// of the active PromptContext.
+func (s *commonState) cancelled(err error) bool {
+	return s.ctx != nil && err != nil && err == s.ctx.Err()
+}
+
+// writeStyled writes text in the SGR style given by its parameters, such
+// as "1;31" for bold red. The style is left out if the output cannot
+// display it.
+func (s *commonState) writeStyled(text string, style string) error {
+	if s.noStyles || style == "" || text == "" {
+		_, err := fmt.Fprint(s.w, text)
+		return err
+	}
+	_, err := fmt.Fprintf(s.w, "\x1b[%sm%s\x1b[0m", style, text)
+	return err
+}
+
 func (s *State) promptUnsupported(p string) (string, error) {
+	// Without line editing, the terminal may not handle styles either
+	p = string(stripSGR([]rune(p)))
 	if !s.inputRedirected || !s.terminalSupported {
-		fmt.Print(p)
+		fmt.Fprint(s.w, p)
 	}
 	linebuf, _, err := s.r.ReadLine()
 	if err != nil {
 		return "", err
 	}
-	return string(linebuf), nil
+	text := string(linebuf)
+	for s.isComplete != nil && !s.isComplete(text) {
+		if !s.inputRedirected || !s.terminalSupported {
+			fmt.Fprint(s.w, string(stripSGR([]rune(s.continuationPrompt))))
+		}
+		linebuf, _, err = s.r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		text += "\n" + string(linebuf)
+	}
+	return text, nil
+}
+
+// crlfWriter translates each "\n" written to it into "\r\n", as the line
+// discipline of a local terminal would.
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c crlfWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			m, err := c.w.Write(p)
+			return n + m, err
+		}
+		m, err := c.w.Write(p[:i])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if _, err := io.WriteString(c.w, "\r\n"); err != nil {
+			return n, err
+		}
+		n++
+		p = p[i+1:]
+	}
+	return n, nil
 }
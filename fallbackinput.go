@@ -5,7 +5,10 @@ package liner
 
 import (
 	"bufio"
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 )
 
@@ -20,6 +23,30 @@ func (s *State) Prompt(p string) (string, error) {
 	return s.promptUnsupported(p)
 }
 
+// PromptContext displays p, and then waits for user input, like Prompt.
+// Since input is read without line editing on this operating system, ctx
+// is only checked before the read starts.
+func (s *State) PromptContext(ctx context.Context, p string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return s.promptUnsupported(p)
+}
+
+// Write writes p to the output. Write and Printf are safe to call from
+// another goroutine while Prompt is in progress.
+func (s *State) Write(p []byte) (int, error) {
+	s.outputMutex.Lock()
+	defer s.outputMutex.Unlock()
+	return s.w.Write(p)
+}
+
+// Printf formats according to a format specifier and writes the result to
+// the output, as Write does.
+func (s *State) Printf(format string, a ...interface{}) (int, error) {
+	return s.Write([]byte(fmt.Sprintf(format, a...)))
+}
+
 // PasswordPrompt is not supported in this OS.
 func (s *State) PasswordPrompt(p string) (string, error) {
 	return "", errors.New("liner: function not supported in this terminal")
@@ -30,14 +57,46 @@ func (s *State) PasswordPrompt(p string) (string, error) {
 // Note that this operating system uses a fallback mode without line
 // editing. Patches welcome.
 func NewLiner() *State {
+	return NewLinerWithOptions(Options{})
+}
+
+// NewLinerWithOptions initializes a new *State that reads from and writes to
+// the streams described by o. Terminal and Columns are ignored, since this
+// operating system uses a fallback mode without line editing.
+func NewLinerWithOptions(o Options) *State {
+	var s State
+	in, out := o.Input, o.Output
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+	s.r = bufio.NewReader(in)
+	s.w = out
+	return &s
+}
+
+// NewSessionLiner initializes a new *State for a terminal that is reached
+// through a byte stream, such as an SSH channel or a net.Conn. Prompts are
+// written to rw, with newlines translated to CRLF, and lines are read from
+// rw without line editing.
+func NewSessionLiner(rw io.ReadWriter, columns int) *State {
 	var s State
-	s.r = bufio.NewReader(os.Stdin)
+	s.r = bufio.NewReader(rw)
+	s.w = crlfWriter{rw}
+	s.session = true
 	return &s
 }
 
+// Resize has no effect on this operating system, since line editing is
+// not supported.
+func (s *State) Resize(columns int) {
+}
+
 // Close returns the terminal to its previous mode
 func (s *State) Close() error {
-	return nil
+	return s.closeHistory()
 }
 
 // TerminalSupported returns false because line editing is not
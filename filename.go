@@ -0,0 +1,101 @@
+package liner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FilenameCompleter is a CandidateCompleter that completes the word under
+// the cursor as the path of a file or directory. A leading "~" stands for
+// the home directory, hidden files are only listed if the last element of
+// the word begins with ".", directories are completed with a trailing "/",
+// and names are escaped, or quoted if the word starts with a quote, as a
+// shell would expect.
+func FilenameCompleter(line string, pos int) (head string, candidates []Candidate, tail string) {
+	return ShellWords(func(args []string, index int, prefix string) []Candidate {
+		return Filenames(prefix)
+	})(line, pos)
+}
+
+// Filenames returns the paths of the files and directories that begin with
+// prefix, as candidates for an ArgsCompleter. The rules for "~", hidden
+// files and directories are those of FilenameCompleter.
+func Filenames(prefix string) []Candidate {
+	if prefix == "~" {
+		return []Candidate{{Text: "~", Suffix: "/"}}
+	}
+
+	dir, base := "", prefix
+	if i := strings.LastIndexAny(prefix, pathSeparators()); i >= 0 {
+		dir, base = prefix[:i+1], prefix[i+1:]
+	}
+	entries, err := os.ReadDir(expandHome(dir))
+	if err != nil {
+		return nil
+	}
+	var candidates []Candidate
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			// Follow links, to complete links to directories with "/"
+			if info, err := os.Stat(filepath.Join(expandHome(dir), name)); err == nil {
+				isDir = info.IsDir()
+			}
+		}
+		c := Candidate{Text: dir + name, Display: name, Suffix: " "}
+		if isDir {
+			c.Display += "/"
+			c.Suffix = "/"
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// FilenameArguments returns a CandidateCompleter that completes the first
+// word of the line, such as the name of a command, with f, and the words
+// after it, its arguments, with FilenameCompleter. If f returns candidates
+// for an argument, they are used instead of file names. f may be nil, to
+// complete only the arguments.
+func FilenameArguments(f CandidateCompleter) CandidateCompleter {
+	return func(line string, pos int) (string, []Candidate, string) {
+		runes := []rune(line)
+		if pos < 0 || pos > len(runes) {
+			return line, nil, ""
+		}
+		_, index := shellWordAt(shellSplit(runes), pos)
+		argument := index > 0
+		if f != nil {
+			head, candidates, tail := f(line, pos)
+			if len(candidates) > 0 || !argument {
+				return head, candidates, tail
+			}
+		}
+		if !argument {
+			return line, nil, ""
+		}
+		return FilenameCompleter(line, pos)
+	}
+}
+
+// expandHome returns the directory that dir names, with a leading "~"
+// replaced by the home directory, or "." if dir is empty
+func expandHome(dir string) string {
+	if dir == "" {
+		return "."
+	}
+	if len(dir) >= 2 && dir[0] == '~' && strings.ContainsRune(pathSeparators(), rune(dir[1])) {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + dir[1:]
+		}
+	}
+	return dir
+}
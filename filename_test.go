@@ -0,0 +1,102 @@
+//go:build linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilenameCompleter(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"alpha.txt", "my file", ".hidden"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "alps"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "alps"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", dir)
+
+	tests := []struct {
+		line       string
+		head, tail string
+		candidates []Candidate
+	}{
+		{"cat " + dir + "/al", "cat ", "", []Candidate{
+			{Text: dir + "/alpha.txt", Display: "alpha.txt", Suffix: " "},
+			{Text: dir + "/alps", Display: "alps/", Suffix: "/"},
+		}},
+		{"cat " + dir + "/", "cat ", "", []Candidate{
+			{Text: dir + "/alpha.txt", Display: "alpha.txt", Suffix: " "},
+			{Text: dir + "/alps", Display: "alps/", Suffix: "/"},
+			{Text: dir + "/link", Display: "link/", Suffix: "/"},
+			{Text: dir + `/my\ file`, Display: "my file", Suffix: " "},
+		}},
+		{"cat " + dir + "/.h", "cat ", "", []Candidate{
+			{Text: dir + "/.hidden", Display: ".hidden", Suffix: " "},
+		}},
+		{"cat " + dir + `/my\ f`, "cat ", "", []Candidate{
+			{Text: dir + `/my\ file`, Display: "my file", Suffix: " "},
+		}},
+		{`cat "` + dir + `/my`, "cat ", "", []Candidate{
+			{Text: `"` + dir + `/my file`, Display: "my file", Suffix: `" `},
+		}},
+		{"cat '" + dir + "/my", "cat ", "", []Candidate{
+			{Text: "'" + dir + "/my file", Display: "my file", Suffix: "' "},
+		}},
+		{"cat ~/m", "cat ", "", []Candidate{
+			{Text: `~/my\ file`, Display: "my file", Suffix: " "},
+		}},
+		{`cat ~/"m`, "cat ", "", []Candidate{
+			{Text: `~/"my file`, Display: "my file", Suffix: `" `},
+		}},
+		{"cat ~", "cat ", "", []Candidate{{Text: "~", Suffix: "/"}}},
+		{"cat " + dir + "/none", "cat ", "", nil},
+	}
+	for _, test := range tests {
+		head, candidates, tail := FilenameCompleter(test.line, len([]rune(test.line)))
+		if head != test.head || tail != test.tail || !reflect.DeepEqual(candidates, test.candidates) {
+			t.Errorf("Completing %q: expected %q, %+v, %q, got %q, %+v, %q", test.line,
+				test.head, test.candidates, test.tail, head, candidates, tail)
+		}
+	}
+}
+
+func TestFilenameArguments(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notes"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	complete := FilenameArguments(func(line string, pos int) (string, []Candidate, string) {
+		if strings.HasPrefix(line, "git ") {
+			return "git ", []Candidate{{Text: "commit"}}, ""
+		}
+		if strings.Contains(line, " ") {
+			return line, nil, ""
+		}
+		return "", []Candidate{{Text: "cat"}, {Text: "git"}}, ""
+	})
+	tests := []struct {
+		line string
+		text string
+	}{
+		{"c", "cat"},
+		{"cat " + dir + "/n", dir + "/notes"},
+		{"git c", "commit"},
+	}
+	for _, test := range tests {
+		_, candidates, _ := complete(test.line, len(test.line))
+		if len(candidates) == 0 || candidates[0].Text != test.text {
+			t.Errorf("Completing %q: expected %q, got %+v", test.line, test.text, candidates)
+		}
+	}
+}
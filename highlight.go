@@ -0,0 +1,52 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"fmt"
+)
+
+// highlight returns the style of each rune of buf, or nil if buf is not
+// highlighted
+func (s *State) highlight(buf []rune) []string {
+	if s.highlighter == nil || s.noStyles {
+		return nil
+	}
+	str := string(buf)
+	spans := s.highlighter(str)
+	if len(spans) == 0 {
+		return nil
+	}
+	styles := make([]string, len(buf))
+	n := 0
+	for i := range str {
+		for _, span := range spans {
+			if span.Start <= i && i < span.End {
+				styles[n] = span.Style
+			}
+		}
+		n++
+	}
+	return styles
+}
+
+// writeHighlighted writes text, where styles (if not nil) holds the style of
+// each rune of text
+func (s *State) writeHighlighted(text []rune, styles []string) error {
+	if styles == nil {
+		_, err := fmt.Fprint(s.w, string(text))
+		return err
+	}
+	for start := 0; start < len(text); {
+		end := start + 1
+		for end < len(text) && styles[end] == styles[start] {
+			end++
+		}
+		if err := s.writeStyled(string(text[start:end]), styles[start]); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
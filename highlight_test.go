@@ -0,0 +1,37 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlighter(t *testing.T) {
+	// The line fits in 80 columns, but is scrolled in 20
+	for _, columns := range []int{80, 20} {
+		s := newTestSession(t, columns)
+		s.SetHighlighter(func(line string) []Span {
+			i := strings.Index(line, "select")
+			if i < 0 {
+				return nil
+			}
+			return []Span{{i, i + len("select"), "1;34"}}
+		})
+
+		s.send("\r")
+		line, err := s.PromptWithSuggestion("> ", "é select * from table_name", 4)
+		if err != nil {
+			t.Fatal("Unexpected error from Prompt", err)
+		}
+		if line != "é select * from table_name" {
+			t.Errorf("Expected %q, got %q", "é select * from table_name", line)
+		}
+		out := s.output.String()
+		if !strings.Contains(out, "é \x1b[1;34mselect\x1b[0m") &&
+			!strings.Contains(out, "{\x1b[1;34mselect\x1b[0m") {
+			t.Errorf("Line was not highlighted in %q", out)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package liner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// HistoryStore holds the scrollback history that Prompt moves through with
+// Up and Down (or Ctrl-P and Ctrl-N) and searches with Ctrl-R. A store may
+// also implement Clear() error, for ClearHistory,
+// UpdateLast(func(*HistoryEntry)) error, for SetLastHistoryStatus, and
+// AppendEntries([]HistoryEntry) error, to add the entries that ReadHistory
+// reads at once.
+//
+// A State calls its store with its history lock held. Iterate, Search and
+// Len may be called concurrently with each other, since WriteHistory may be
+// called while Prompt is in progress, but not with the other methods.
+type HistoryStore interface {
+	// Append adds entry to the end of the history
+	Append(entry HistoryEntry) error
+	// Iterate calls f with each entry of the history, oldest first if
+	// forward is true and newest first otherwise, until f returns false
+	Iterate(forward bool, f func(entry HistoryEntry) bool) error
+	// Search returns the entries whose Line contains query, oldest first
+	Search(query string) ([]HistoryEntry, error)
+	// Len returns the number of entries in the history
+	Len() int
+	// Close releases the store's resources. A State closes its store when
+	// the State is closed.
+	Close() error
+}
+
+type historyClearer interface {
+	Clear() error
+}
+
+type historyUpdater interface {
+	UpdateLast(update func(entry *HistoryEntry)) error
+}
+
+type historyBatcher interface {
+	AppendEntries(entries []HistoryEntry) error
+}
+
+// MemoryHistory is a HistoryStore that holds the most recent HistoryLimit
+// entries in memory. It is the store that a State uses unless
+// SetHistoryStore is called.
+type MemoryHistory struct {
+	entries []HistoryEntry
+}
+
+// Append adds entry to the end of the history, and drops the oldest entry
+// if there are more than HistoryLimit.
+func (m *MemoryHistory) Append(entry HistoryEntry) error {
+	m.entries = append(m.entries, entry)
+	if len(m.entries) > HistoryLimit {
+		m.entries = m.entries[1:]
+	}
+	return nil
+}
+
+// Iterate calls f with each entry, in the order that forward selects,
+// until f returns false.
+func (m *MemoryHistory) Iterate(forward bool, f func(entry HistoryEntry) bool) error {
+	if forward {
+		for _, entry := range m.entries {
+			if !f(entry) {
+				break
+			}
+		}
+		return nil
+	}
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		if !f(m.entries[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+// Search returns the entries whose Line contains query, oldest first.
+func (m *MemoryHistory) Search(query string) ([]HistoryEntry, error) {
+	var found []HistoryEntry
+	for _, entry := range m.entries {
+		if strings.Contains(entry.Line, query) {
+			found = append(found, entry)
+		}
+	}
+	return found, nil
+}
+
+// Len returns the number of entries.
+func (m *MemoryHistory) Len() int {
+	return len(m.entries)
+}
+
+// Close does nothing.
+func (m *MemoryHistory) Close() error {
+	return nil
+}
+
+// Clear removes every entry.
+func (m *MemoryHistory) Clear() error {
+	m.entries = nil
+	return nil
+}
+
+// UpdateLast calls update to change the newest entry, if there is one.
+func (m *MemoryHistory) UpdateLast(update func(entry *HistoryEntry)) error {
+	if len(m.entries) > 0 {
+		update(&m.entries[len(m.entries)-1])
+	}
+	return nil
+}
+
+// HistoryFile is a HistoryStore that keeps a file up to date with the
+// history. Each entry is written to the file, in the format of
+// WriteHistory, as soon as it is appended, so that the history survives a
+// crash.
+type HistoryFile struct {
+	MemoryHistory
+	path     string
+	f        *os.File
+	extended bool // whether the file starts with historyHeader
+}
+
+// OpenHistoryFile returns a HistoryFile that reads the history from the
+// file at path, and adds entries to it. The file is created if it does not
+// exist.
+func OpenHistoryFile(path string) (*HistoryFile, error) {
+	h := &HistoryFile{path: path}
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		var num int
+		num, h.extended, err = readHistory(f, h.MemoryHistory.Append)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if num > HistoryLimit {
+			// Drop the entries that no longer fit from the file too
+			if err := h.rewrite(); err != nil {
+				h.Close()
+				return nil, err
+			}
+			return h, nil
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	if h.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Append adds entry to the end of the history, and to the end of the file.
+// A plain history file is rewritten with a header the first time an entry
+// with details is appended to it.
+func (h *HistoryFile) Append(entry HistoryEntry) error {
+	h.MemoryHistory.Append(entry)
+	if entry.extended() && !h.extended {
+		return h.rewrite()
+	}
+	if err := writeHistoryEntry(h.f, entry); err != nil {
+		return err
+	}
+	return h.f.Sync()
+}
+
+// AppendEntries adds entries to the end of the history, and writes them to
+// the end of the file at once.
+func (h *HistoryFile) AppendEntries(entries []HistoryEntry) error {
+	for _, entry := range entries {
+		h.MemoryHistory.Append(entry)
+	}
+	if extendedHistory(entries) && !h.extended {
+		return h.rewrite()
+	}
+	w := bufio.NewWriter(h.f)
+	for _, entry := range entries {
+		if err := writeHistoryEntry(w, entry); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return h.f.Sync()
+}
+
+// Clear removes every entry, from the file too.
+func (h *HistoryFile) Clear() error {
+	h.MemoryHistory.Clear()
+	return h.rewrite()
+}
+
+// UpdateLast calls update to change the newest entry, and appends a line
+// with its new details to the file. Unlike an appended entry, the update
+// is not synced to disk, since losing it in a crash loses little. A plain
+// history file is rewritten with a header the first time it needs one.
+func (h *HistoryFile) UpdateLast(update func(entry *HistoryEntry)) error {
+	h.MemoryHistory.UpdateLast(update)
+	if len(h.entries) == 0 {
+		return nil
+	}
+	last := h.entries[len(h.entries)-1]
+	if !h.extended {
+		if last.extended() {
+			return h.rewrite()
+		}
+		return nil
+	}
+	_, err := fmt.Fprintln(h.f, last.updateLine())
+	return err
+}
+
+// Close closes the file.
+func (h *HistoryFile) Close() error {
+	if h.f == nil {
+		return nil
+	}
+	return h.f.Close()
+}
+
+// rewrite replaces the file with the entries held in memory. The entries
+// are written to a temporary file that is renamed over the file, so that a
+// crash leaves either the old file or the new one.
+func (h *HistoryFile) rewrite() error {
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = writeHistory(tmp, h.entries)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Windows cannot rename over a file that is open. The file is reopened
+	// even if the rename fails, so that later entries are still appended.
+	if h.f != nil {
+		h.f.Close()
+	}
+	renameErr := os.Rename(tmp.Name(), h.path)
+	if renameErr == nil {
+		h.extended = extendedHistory(h.entries)
+	}
+	h.f, err = os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if renameErr != nil {
+		return renameErr
+	}
+	return err
+}
+
+// readHistory reads the entries of a history file from r, and calls add
+// with each. It returns the number of entries read, and whether the file
+// starts with historyHeader.
+func readHistory(r io.Reader, add func(entry HistoryEntry) error) (num int, extended bool, err error) {
+	in := bufio.NewReader(r)
+	var entry HistoryEntry
+	var text []string // the lines of entry read so far
+	lines := 0        // the number of lines of entry, once its details are read
+
+	// Each entry is added once the next one starts, since update lines
+	// after it may still change its details
+	var last HistoryEntry
+	pending := false
+	flush := func() error {
+		if !pending {
+			return nil
+		}
+		pending = false
+		num++
+		return add(last)
+	}
+	// fail adds the entry read before err, and returns err
+	fail := func(err error) (int, bool, error) {
+		if ferr := flush(); ferr != nil {
+			err = ferr
+		}
+		return num, extended, err
+	}
+
+	for n := 1; ; n++ {
+		line, part, err := in.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if part {
+			return fail(fmt.Errorf("line %d is too long", n))
+		}
+		if !utf8.Valid(line) {
+			return fail(fmt.Errorf("invalid string at line %d", n))
+		}
+		if n == 1 && string(line) == historyHeader {
+			extended = true
+			continue
+		}
+		if extended && lines == 0 {
+			if details, count, ok := parseDetails(string(line)); ok {
+				if count == 0 {
+					// An update of the entry before, if there is one
+					if pending {
+						details.Line = last.Line
+						last = details
+					}
+					continue
+				}
+				entry, lines = details, count
+				continue
+			}
+		}
+		text = append(text, string(line))
+		if len(text) < lines {
+			continue
+		}
+		if err := flush(); err != nil {
+			return num, extended, err
+		}
+		entry.Line = strings.Join(text, "\n")
+		last, pending = entry, true
+		entry, text, lines = HistoryEntry{}, nil, 0
+	}
+	if len(text) > 0 {
+		return fail(fmt.Errorf("history ends within an entry of %d lines", lines))
+	}
+	if err := flush(); err != nil {
+		return num, extended, err
+	}
+	return num, extended, nil
+}
+
+// extendedHistory reports whether entries can only be written to a history
+// file that starts with historyHeader
+func extendedHistory(entries []HistoryEntry) bool {
+	for _, entry := range entries {
+		if entry.extended() {
+			return true
+		}
+	}
+	return false
+}
+
+// writeHistory writes entries to w, with historyHeader first if they need
+// it, and returns the number of entries written.
+func writeHistory(w io.Writer, entries []HistoryEntry) (num int, err error) {
+	if extendedHistory(entries) {
+		if _, err := fmt.Fprintln(w, historyHeader); err != nil {
+			return 0, err
+		}
+	}
+	for _, entry := range entries {
+		if err := writeHistoryEntry(w, entry); err != nil {
+			return num, err
+		}
+		num++
+	}
+	return num, nil
+}
+
+// writeHistoryEntry writes entry to w, preceded by a line that holds its
+// details if it needs one. Only a file that starts with historyHeader may
+// hold such an entry.
+func writeHistoryEntry(w io.Writer, entry HistoryEntry) error {
+	if entry.extended() {
+		if _, err := fmt.Fprintln(w, entry.detailsLine()); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, entry.Line)
+	return err
+}
@@ -0,0 +1,232 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryHistory(t *testing.T) {
+	var m MemoryHistory
+	for _, line := range []string{"make", "make test", "go test"} {
+		m.Append(HistoryEntry{Line: line})
+	}
+	if m.Len() != 3 {
+		t.Fatal("Expected 3 entries, got", m.Len())
+	}
+
+	var lines []string
+	m.Iterate(false, func(entry HistoryEntry) bool {
+		lines = append(lines, entry.Line)
+		return len(lines) < 2
+	})
+	if !reflect.DeepEqual(lines, []string{"go test", "make test"}) {
+		t.Error("Unexpected entries iterating backward", lines)
+	}
+
+	found, err := m.Search("test")
+	if err != nil || len(found) != 2 || found[0].Line != "make test" || found[1].Line != "go test" {
+		t.Error("Unexpected search result", found, err)
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h, err := OpenHistoryFile(path)
+	if err != nil {
+		t.Fatal("Unexpected error opening history file", err)
+	}
+	var s State
+	s.SetHistoryStore(h)
+	s.AppendHistory("make")
+	s.AppendHistoryEntry(HistoryEntry{Line: "make test", Time: time.Unix(1700000000, 0)})
+	s.SetLastHistoryStatus(1)
+	s.AppendHistory("go test")
+
+	// The entries are in the file before the store is closed, as they would
+	// be after a crash
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("Unexpected error reading history file", err)
+	}
+	expected := "#liner-history v2\nmake\n#1700000000\nmake test\n#1700000000 lines=0 status=1\ngo test\n"
+	if string(written) != expected {
+		t.Fatalf("Expected %q, got %q", expected, written)
+	}
+
+	s.AppendHistory("select 1\nfrom t;")
+
+	reopened, err := OpenHistoryFile(path)
+	if err != nil {
+		t.Fatal("Unexpected error reopening history file", err)
+	}
+	var s2 State
+	s2.SetHistoryStore(reopened)
+	if !reflect.DeepEqual(s2.HistoryEntries(nil), s.HistoryEntries(nil)) {
+		t.Fatalf("Round-trip failure: %+v", s2.HistoryEntries(nil))
+	}
+	if n := len(s2.HistoryEntries(nil)); n != 4 {
+		t.Errorf("Expected 4 entries after reopening, got %d", n)
+	}
+	if err := h.Close(); err != nil {
+		t.Error("Unexpected error closing history file", err)
+	}
+
+	s2.ClearHistory()
+	s2.AppendHistory("ls")
+	if err := reopened.Close(); err != nil {
+		t.Error("Unexpected error closing history file", err)
+	}
+	written, err = ioutil.ReadFile(path)
+	if err != nil || string(written) != "ls\n" {
+		t.Errorf("Expected only the entry after clearing, got %q (%v)", written, err)
+	}
+}
+
+func TestReadHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h, err := OpenHistoryFile(path)
+	if err != nil {
+		t.Fatal("Unexpected error opening history file", err)
+	}
+	defer h.Close()
+	var s State
+	s.SetHistoryStore(h)
+	s.AppendHistory("ls")
+
+	input := "#liner-history v2\n#1700000000\nmake\n#0 lines=2\nselect 1\nfrom t;\n"
+	if num, err := s.ReadHistory(strings.NewReader(input)); num != 2 || err != nil {
+		t.Fatalf("Expected 2 entries read, got %d (%v)", num, err)
+	}
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal("Unexpected error reading history file", err)
+	}
+	expected := "#liner-history v2\nls\n#1700000000\nmake\n#0 lines=2\nselect 1\nfrom t;\n"
+	if string(written) != expected {
+		t.Errorf("Expected %q, got %q", expected, written)
+	}
+
+	s.AppendHistory("pwd")
+	if num, err := s.ReadHistory(strings.NewReader("cd\n#not details\n")); num != 2 || err != nil {
+		t.Fatalf("Expected 2 entries read, got %d (%v)", num, err)
+	}
+	written, err = ioutil.ReadFile(path)
+	if err != nil || !strings.HasSuffix(string(written), "\npwd\ncd\n#not details\n") {
+		t.Errorf("Expected the entries appended after pwd, got %q (%v)", written, err)
+	}
+}
+
+func TestHistoryErrorHandler(t *testing.T) {
+	h, err := OpenHistoryFile(filepath.Join(t.TempDir(), "history"))
+	if err != nil {
+		t.Fatal("Unexpected error opening history file", err)
+	}
+	var s State
+	s.SetHistoryStore(h)
+	var reported []error
+	s.SetHistoryErrorHandler(func(err error) {
+		reported = append(reported, err)
+	})
+	s.AppendHistory("ls")
+	if len(reported) != 0 {
+		t.Fatal("Unexpected errors appending history", reported)
+	}
+
+	// Writes fail once the file is closed
+	h.Close()
+	s.AppendHistory("make")
+	if len(reported) != 1 {
+		t.Fatal("Expected the failed write to be reported, got", reported)
+	}
+}
+
+// appendOnlyHistory is a HistoryStore that cannot clear its entries
+type appendOnlyHistory struct {
+	m      MemoryHistory
+	closed bool
+}
+
+func (a *appendOnlyHistory) Append(entry HistoryEntry) error { return a.m.Append(entry) }
+func (a *appendOnlyHistory) Len() int                        { return a.m.Len() }
+func (a *appendOnlyHistory) Close() error                    { a.closed = true; return nil }
+
+func (a *appendOnlyHistory) Iterate(forward bool, f func(entry HistoryEntry) bool) error {
+	return a.m.Iterate(forward, f)
+}
+
+func (a *appendOnlyHistory) Search(query string) ([]HistoryEntry, error) {
+	return a.m.Search(query)
+}
+
+func TestClearHistoryStore(t *testing.T) {
+	var s State
+	store := &appendOnlyHistory{}
+	s.SetHistoryStore(store)
+	s.AppendHistory("ls")
+	s.ClearHistory()
+	if !store.closed {
+		t.Error("Expected the replaced store to be closed")
+	}
+	if entries := s.HistoryEntries(nil); len(entries) != 0 {
+		t.Error("Expected no entries after clearing, got", entries)
+	}
+}
+
+// recordingHistory is a HistoryStore that records the lines searched for
+type recordingHistory struct {
+	MemoryHistory
+	searches []string
+	closed   bool
+}
+
+func (r *recordingHistory) Search(query string) ([]HistoryEntry, error) {
+	r.searches = append(r.searches, query)
+	return r.MemoryHistory.Search(query)
+}
+
+func (r *recordingHistory) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestHistoryStore(t *testing.T) {
+	s := newTestSession(t, 80)
+	store := &recordingHistory{}
+	store.Append(HistoryEntry{Line: "git status"})
+	store.Append(HistoryEntry{Line: "go test"})
+	if old := s.SetHistoryStore(store); old != nil {
+		t.Error("Expected no previous store, got", old)
+	}
+
+	tests := []struct {
+		input, line string
+	}{
+		{"\x10\x10\r", "git status"},
+		{"g\x10\x10\x0e\r", "go test"},
+		{"\x12stat\r", "git status"},
+	}
+	for _, test := range tests {
+		if line := promptWith(t, s, test.input); line != test.line {
+			t.Errorf("Expected %q after %q, got %q", test.line, test.input, line)
+		}
+	}
+	if len(store.searches) == 0 {
+		t.Error("Expected the history to be searched through the store")
+	}
+
+	s.AppendHistory("ls")
+	if store.Len() != 3 {
+		t.Error("Expected the store to hold the appended entry, got", store.entries)
+	}
+	s.Close()
+	if !store.closed {
+		t.Error("Expected Close to close the store")
+	}
+}
@@ -5,44 +5,71 @@ package liner
 
 import (
 	"bufio"
-	"errors"
+	"io"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
-	"time"
 )
 
-type nexter struct {
-	r   rune
-	err error
-}
-
 // State represents an open terminal
 type State struct {
 	commonState
 	origMode    termios
 	defaultMode termios
-	next        <-chan nexter
-	winch       chan os.Signal
-	pending     []rune
-	useCHA      bool
+	inFd        int
+	outFd       int
+	streamInput
 }
 
 // NewLiner initializes a new *State, and sets the terminal into raw mode. To
 // restore the terminal to its previous state, call State.Close().
 func NewLiner() *State {
+	return NewLinerWithOptions(Options{})
+}
+
+// NewLinerWithOptions initializes a new *State that reads from and writes to
+// the streams described by o, and sets the terminal (if any) into raw mode.
+// To restore the terminal to its previous state, call State.Close().
+func NewLinerWithOptions(o Options) *State {
 	var s State
-	s.r = bufio.NewReader(os.Stdin)
+	in, out := o.Input, o.Output
+	s.inFd, s.outFd = -1, -1
+	if o.Terminal != nil {
+		s.inFd = int(o.Terminal.Fd())
+		s.outFd = s.inFd
+		if in == nil {
+			in = o.Terminal
+		}
+		if out == nil {
+			out = o.Terminal
+		}
+	}
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+	if f, ok := in.(*os.File); ok && s.inFd < 0 {
+		s.inFd = int(f.Fd())
+	}
+	if f, ok := out.(*os.File); ok && s.outFd < 0 {
+		s.outFd = int(f.Fd())
+	}
+	s.in = in
+	s.r = bufio.NewReader(in)
+	s.w = out
+	s.columnsFunc = o.Columns
 
 	s.terminalSupported = TerminalSupported()
-	if m, err := TerminalMode(); err == nil {
-		s.origMode = *m.(*termios)
+	if m, err := getMode(s.inFd); err == nil {
+		s.origMode = *m
 	} else {
 		s.inputRedirected = true
 	}
-	if _, err := getMode(syscall.Stdout); err != nil {
+	if _, err := getMode(s.outFd); err != nil && s.columnsFunc == nil {
 		s.outputRedirected = true
 	}
 	if s.inputRedirected && s.outputRedirected {
@@ -55,7 +82,7 @@ func NewLiner() *State {
 		mode.Lflag &^= syscall.ECHO | icanon | iexten
 		mode.Cc[syscall.VMIN] = 1
 		mode.Cc[syscall.VTIME] = 0
-		mode.ApplyMode()
+		setMode(s.inFd, &mode)
 
 		winch := make(chan os.Signal, 1)
 		signal.Notify(winch, syscall.SIGWINCH)
@@ -71,17 +98,56 @@ func NewLiner() *State {
 	return &s
 }
 
-var errTimedOut = errors.New("timeout")
+// NewSessionLiner initializes a new *State for a terminal that is reached
+// through a byte stream rather than a local tty, such as an SSH channel or
+// a net.Conn to a telnet-style client. Keystrokes are read from rw and
+// everything liner draws is written to rw, with newlines translated to
+// CRLF. The remote terminal is expected to already be in raw mode (for
+// SSH, by the client's "pty-req"), and to be columns wide. Report later
+// changes in width with Resize.
+func NewSessionLiner(rw io.ReadWriter, columns int) *State {
+	var s State
+	s.inFd, s.outFd = -1, -1
+	s.in = rw
+	s.r = bufio.NewReader(rw)
+	s.w = crlfWriter{rw}
+	s.terminalSupported = true
+	s.session = true
+	s.sessionColumns = int32(columns)
+	s.columnsFunc = func() int {
+		return int(atomic.LoadInt32(&s.sessionColumns))
+	}
+	s.winch = make(chan os.Signal, 1)
+	s.getColumns()
+	return &s
+}
+
+// Resize records that the terminal of a State created by NewSessionLiner
+// is now columns wide, for example after an SSH "window-change" request.
+// Unlike the rest of liner's API, Resize is safe to call from another
+// goroutine while Prompt is in progress. Resize has no effect on other
+// States, which track the size of their terminal themselves.
+func (s *State) Resize(columns int) {
+	if !s.session {
+		return
+	}
+	atomic.StoreInt32(&s.sessionColumns, int32(columns))
+	select {
+	case s.winch <- syscall.SIGWINCH:
+	default:
+	}
+}
 
 func (s *State) startPrompt() {
-	if s.terminalSupported {
-		if m, err := TerminalMode(); err == nil {
-			s.defaultMode = *m.(*termios)
+	if s.terminalSupported && !s.session {
+		if m, err := getMode(s.inFd); err == nil {
+			s.defaultMode = *m
 			mode := s.defaultMode
 			mode.Lflag &^= isig
-			mode.ApplyMode()
+			setMode(s.inFd, &mode)
 		}
 	}
+	s.setBracketedPaste(true)
 	s.restartPrompt()
 }
 
@@ -90,276 +156,53 @@ func (s *State) inputWaiting() bool {
 }
 
 func (s *State) restartPrompt() {
-	next := make(chan nexter, 200)
-	go func() {
-		for {
-			var n nexter
-			n.r, _, n.err = s.r.ReadRune()
-			next <- n
-			// Shut down nexter loop when an end condition has been reached
-			if n.err != nil || n.r == '\n' || n.r == '\r' || n.r == ctrlC || n.r == ctrlD {
-				close(next)
-				return
-			}
-		}
-	}()
-	s.next = next
+	s.startReading()
 }
 
 func (s *State) stopPrompt() {
-	if s.terminalSupported {
-		s.defaultMode.ApplyMode()
+	s.setBracketedPaste(false)
+	if s.terminalSupported && !s.session {
+		setMode(s.inFd, &s.defaultMode)
 	}
 }
 
-func (s *State) nextPending(timeout <-chan time.Time) (rune, error) {
-	select {
-	case thing, ok := <-s.next:
-		if !ok {
-			return 0, ErrInternal
-		}
-		if thing.err != nil {
-			return 0, thing.err
-		}
-		s.pending = append(s.pending, thing.r)
-		return thing.r, nil
-	case <-timeout:
-		rv := s.pending[0]
-		s.pending = s.pending[1:]
-		return rv, errTimedOut
-	}
+func (s *State) readInput() (interface{}, error) {
+	return s.readStream()
 }
 
-func (s *State) readNext() (interface{}, error) {
-	if len(s.pending) > 0 {
-		rv := s.pending[0]
-		s.pending = s.pending[1:]
-		return rv, nil
-	}
-	var r rune
-	select {
-	case thing, ok := <-s.next:
-		if !ok {
-			return 0, ErrInternal
-		}
-		if thing.err != nil {
-			return nil, thing.err
-		}
-		r = thing.r
-	case <-s.winch:
-		s.getColumns()
-		return winch, nil
-	}
-	if r != esc {
-		return r, nil
+// Close returns the terminal to its previous mode. If a prompt was
+// cancelled (see PromptContext), Close also stops the read of the input
+// that it left waiting, if the input supports read deadlines. Otherwise
+// that read takes the next keystroke.
+func (s *State) Close() error {
+	s.stopReading()
+	signal.Stop(s.winch)
+	if !s.inputRedirected && !s.session {
+		setMode(s.inFd, &s.origMode)
 	}
-	s.pending = append(s.pending, r)
+	return s.closeHistory()
+}
 
-	// Wait at most 50 ms for the rest of the escape sequence
-	// If nothing else arrives, it was an actual press of the esc key
-	timeout := time.After(50 * time.Millisecond)
-	flag, err := s.nextPending(timeout)
-	if err != nil {
-		if err == errTimedOut {
-			return flag, nil
-		}
-		return unknown, err
-	}
+// fdMode is a terminal mode that applies to a particular descriptor
+type fdMode struct {
+	fd   int
+	mode *termios
+}
 
-	switch flag {
-	case '[':
-		code, err := s.nextPending(timeout)
-		if err != nil {
-			if err == errTimedOut {
-				return code, nil
-			}
-			return unknown, err
-		}
-		switch code {
-		case 'A':
-			s.pending = s.pending[:0] // escape code complete
-			return up, nil
-		case 'B':
-			s.pending = s.pending[:0] // escape code complete
-			return down, nil
-		case 'C':
-			s.pending = s.pending[:0] // escape code complete
-			return right, nil
-		case 'D':
-			s.pending = s.pending[:0] // escape code complete
-			return left, nil
-		case 'F':
-			s.pending = s.pending[:0] // escape code complete
-			return end, nil
-		case 'H':
-			s.pending = s.pending[:0] // escape code complete
-			return home, nil
-		case 'Z':
-			s.pending = s.pending[:0] // escape code complete
-			return shiftTab, nil
-		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-			num := []rune{code}
-			for {
-				code, err := s.nextPending(timeout)
-				if err != nil {
-					if err == errTimedOut {
-						return code, nil
-					}
-					return nil, err
-				}
-				switch code {
-				case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-					num = append(num, code)
-				case ';':
-					// Modifier code to follow
-					// This only supports Ctrl-left and Ctrl-right for now
-					x, _ := strconv.ParseInt(string(num), 10, 32)
-					if x != 1 {
-						// Can't be left or right
-						rv := s.pending[0]
-						s.pending = s.pending[1:]
-						return rv, nil
-					}
-					num = num[:0]
-					for {
-						code, err = s.nextPending(timeout)
-						if err != nil {
-							if err == errTimedOut {
-								rv := s.pending[0]
-								s.pending = s.pending[1:]
-								return rv, nil
-							}
-							return nil, err
-						}
-						switch code {
-						case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-							num = append(num, code)
-						case 'C', 'D':
-							// right, left
-							mod, _ := strconv.ParseInt(string(num), 10, 32)
-							if mod != 5 {
-								// Not bare Ctrl
-								rv := s.pending[0]
-								s.pending = s.pending[1:]
-								return rv, nil
-							}
-							s.pending = s.pending[:0] // escape code complete
-							if code == 'C' {
-								return wordRight, nil
-							}
-							return wordLeft, nil
-						default:
-							// Not left or right
-							rv := s.pending[0]
-							s.pending = s.pending[1:]
-							return rv, nil
-						}
-					}
-				case '~':
-					s.pending = s.pending[:0] // escape code complete
-					x, _ := strconv.ParseInt(string(num), 10, 32)
-					switch x {
-					case 2:
-						return insert, nil
-					case 3:
-						return del, nil
-					case 5:
-						return pageUp, nil
-					case 6:
-						return pageDown, nil
-					case 1, 7:
-						return home, nil
-					case 4, 8:
-						return end, nil
-					case 15:
-						return f5, nil
-					case 17:
-						return f6, nil
-					case 18:
-						return f7, nil
-					case 19:
-						return f8, nil
-					case 20:
-						return f9, nil
-					case 21:
-						return f10, nil
-					case 23:
-						return f11, nil
-					case 24:
-						return f12, nil
-					default:
-						return unknown, nil
-					}
-				default:
-					// unrecognized escape code
-					rv := s.pending[0]
-					s.pending = s.pending[1:]
-					return rv, nil
-				}
-			}
-		}
+func (m fdMode) ApplyMode() error {
+	return setMode(m.fd, m.mode)
+}
 
-	case 'O':
-		code, err := s.nextPending(timeout)
-		if err != nil {
-			if err == errTimedOut {
-				return code, nil
-			}
-			return nil, err
-		}
-		s.pending = s.pending[:0] // escape code complete
-		switch code {
-		case 'c':
-			return wordRight, nil
-		case 'd':
-			return wordLeft, nil
-		case 'H':
-			return home, nil
-		case 'F':
-			return end, nil
-		case 'P':
-			return f1, nil
-		case 'Q':
-			return f2, nil
-		case 'R':
-			return f3, nil
-		case 'S':
-			return f4, nil
-		default:
-			return unknown, nil
-		}
-	case 'b':
-		s.pending = s.pending[:0] // escape code complete
-		return altB, nil
-	case 'd':
-		s.pending = s.pending[:0] // escape code complete
-		return altD, nil
-	case bs:
-		s.pending = s.pending[:0] // escape code complete
-		return altBs, nil
-	case 'f':
-		s.pending = s.pending[:0] // escape code complete
-		return altF, nil
-	case 'y':
-		s.pending = s.pending[:0] // escape code complete
-		return altY, nil
-	default:
-		rv := s.pending[0]
-		s.pending = s.pending[1:]
-		return rv, nil
+func (s *State) terminalMode() (ModeApplier, error) {
+	m, err := getMode(s.inFd)
+	if err != nil {
+		return nil, err
 	}
-
-	// not reached
-	return r, nil
+	return fdMode{s.inFd, m}, nil
 }
 
-// Close returns the terminal to its previous mode
-func (s *State) Close() error {
-	signal.Stop(s.winch)
-	if !s.inputRedirected {
-		s.origMode.ApplyMode()
-	}
-	return nil
+func (s *State) applyOrigMode() {
+	setMode(s.inFd, &s.origMode)
 }
 
 // TerminalSupported returns true if the current terminal supports
@@ -6,7 +6,12 @@ package liner
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 )
 
 func (s *State) expectRune(t *testing.T, r rune) {
@@ -60,3 +65,39 @@ func TestTypes(t *testing.T) {
 
 	s.expectRune(t, 'e')
 }
+
+// fileSession is a session that reads from a file, which supports read
+// deadlines (except for pipes on Windows)
+type fileSession struct {
+	*os.File
+	out io.Writer
+}
+
+func (f fileSession) Write(p []byte) (int, error) {
+	return f.out.Write(p)
+}
+
+func TestCloseStopsReading(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	s := NewSessionLiner(fileSession{r, ioutil.Discard}, 80)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.PromptContext(ctx, "> "); err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	s.Close()
+
+	// Input after Close is left for whatever reads next
+	io.WriteString(w, "x")
+	r.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != nil || buf[0] != 'x' {
+		t.Fatalf("Expected to read \"x\" after Close, got %q (%v)", buf, err)
+	}
+}
@@ -2,7 +2,9 @@ package liner
 
 import (
 	"bufio"
+	"io"
 	"os"
+	"sync/atomic"
 	"syscall"
 	"unicode/utf16"
 	"unsafe"
@@ -39,8 +41,11 @@ type State struct {
 	hOut        syscall.Handle
 	origMode    inputMode
 	defaultMode inputMode
+	origOutMode inputMode
 	key         interface{}
 	repeat      uint16
+	ansiOutput  bool // the output is not a console, and is driven with escape sequences
+	streamInput      // for sessions
 }
 
 const (
@@ -51,30 +56,73 @@ const (
 	enableProcessedInput = 0x1
 	enableQuickEditMode  = 0x40
 	enableWindowInput    = 0x8
+
+	enableVirtualTerminalProcessing = 0x4 // an output mode
 )
 
 // NewLiner initializes a new *State, and sets the terminal into raw mode. To
 // restore the terminal to its previous state, call State.Close().
 func NewLiner() *State {
+	return NewLinerWithOptions(Options{})
+}
+
+// NewLinerWithOptions initializes a new *State that reads from and writes to
+// the streams described by o, and sets the console (if any) into raw mode.
+// To restore the console to its previous state, call State.Close().
+//
+// Line editing requires Input to be a console; any other Input is read
+// without line editing. An Output that is not a console is driven with
+// ANSI escape sequences, as on other systems, if Columns reports its
+// width; otherwise it is treated as redirected.
+func NewLinerWithOptions(o Options) *State {
 	var s State
-	hIn, _, _ := procGetStdHandle.Call(uintptr(std_input_handle))
-	s.handle = syscall.Handle(hIn)
-	hOut, _, _ := procGetStdHandle.Call(uintptr(std_output_handle))
-	s.hOut = syscall.Handle(hOut)
+	in, out := o.Input, o.Output
+	if in == nil {
+		in = os.Stdin
+	}
+	if out == nil {
+		out = os.Stdout
+	}
+	s.in = in
+	s.w = out
+	s.columnsFunc = o.Columns
+	if f, ok := in.(*os.File); ok {
+		s.handle = syscall.Handle(f.Fd())
+	} else {
+		s.handle = syscall.InvalidHandle
+	}
+	if f, ok := out.(*os.File); ok {
+		s.hOut = syscall.Handle(f.Fd())
+	} else {
+		s.hOut = syscall.InvalidHandle
+	}
 
 	s.terminalSupported = true
-	if m, err := TerminalMode(); err == nil {
-		s.origMode = m.(inputMode)
+	if m, err := getConsoleMode(s.handle); err == nil {
+		s.origMode = m
 		mode := s.origMode
 		mode &^= enableEchoInput
 		mode &^= enableInsertMode
 		mode &^= enableLineInput
 		mode &^= enableMouseInput
 		mode |= enableWindowInput
-		mode.ApplyMode()
+		setConsoleMode(s.handle, mode)
 	} else {
 		s.inputRedirected = true
-		s.r = bufio.NewReader(os.Stdin)
+		s.r = bufio.NewReader(in)
+	}
+
+	// Styled text is written with SGR sequences, which older consoles
+	// cannot display
+	s.noStyles = true
+	if m, err := getConsoleMode(s.hOut); err == nil {
+		s.origOutMode = m
+		if setConsoleMode(s.hOut, m|enableVirtualTerminalProcessing) == nil {
+			s.noStyles = false
+		}
+	} else {
+		s.ansiOutput = true
+		s.noStyles = false
 	}
 
 	s.getColumns()
@@ -83,6 +131,49 @@ func NewLiner() *State {
 	return &s
 }
 
+// NewSessionLiner initializes a new *State for a terminal that is reached
+// through a byte stream rather than the console, such as an SSH channel or
+// a net.Conn to a telnet-style client. Keystrokes are read from rw and
+// everything liner draws is written to rw, with newlines translated to
+// CRLF. The remote terminal is expected to already be in raw mode (for
+// SSH, by the client's "pty-req"), and to be columns wide. Report later
+// changes in width with Resize.
+func NewSessionLiner(rw io.ReadWriter, columns int) *State {
+	var s State
+	s.handle = syscall.InvalidHandle
+	s.hOut = syscall.InvalidHandle
+	s.in = rw
+	s.r = bufio.NewReader(rw)
+	s.w = crlfWriter{rw}
+	s.terminalSupported = true
+	s.session = true
+	s.ansiOutput = true
+	s.sessionColumns = int32(columns)
+	s.columnsFunc = func() int {
+		return int(atomic.LoadInt32(&s.sessionColumns))
+	}
+	s.winch = make(chan os.Signal, 1)
+	s.getColumns()
+	return &s
+}
+
+// Resize records that the terminal of a State created by NewSessionLiner
+// is now columns wide, for example after an SSH "window-change" request.
+// Unlike the rest of liner's API, Resize is safe to call from another
+// goroutine while Prompt is in progress. Resize has no effect on other
+// States, which track the size of the console themselves.
+func (s *State) Resize(columns int) {
+	if !s.session {
+		return
+	}
+	atomic.StoreInt32(&s.sessionColumns, int32(columns))
+	select {
+	// Windows has no SIGWINCH; any signal wakes the prompt
+	case s.winch <- syscall.Signal(0):
+	default:
+	}
+}
+
 // These names are from the Win32 api, so they use underscores (contrary to
 // what golint suggests)
 const (
@@ -154,6 +245,9 @@ const (
 
 // inputWaiting only returns true if the next call to readNext will return immediately.
 func (s *State) inputWaiting() bool {
+	if s.session {
+		return len(s.next) > 0
+	}
 	var num uint32
 	ok, _, _ := procGetNumberOfConsoleInputEvents.Call(uintptr(s.handle), uintptr(unsafe.Pointer(&num)))
 	if ok == 0 {
@@ -167,7 +261,10 @@ func (s *State) inputWaiting() bool {
 	return num > 1
 }
 
-func (s *State) readNext() (interface{}, error) {
+func (s *State) readInput() (interface{}, error) {
+	if s.session {
+		return s.readStream()
+	}
 	if s.repeat > 0 {
 		s.repeat--
 		return s.key, nil
@@ -179,6 +276,25 @@ func (s *State) readNext() (interface{}, error) {
 	var surrogate uint16
 
 	for {
+		if done := s.ctxDone(); done != nil {
+			// Wait for input in short slices, so that cancellation is
+			// noticed promptly
+			for {
+				select {
+				case <-done:
+					return nil, s.ctx.Err()
+				default:
+				}
+				ev, err := syscall.WaitForSingleObject(s.handle, 50)
+				if ev == syscall.WAIT_OBJECT_0 {
+					break
+				}
+				if ev != syscall.WAIT_TIMEOUT {
+					return nil, err
+				}
+			}
+		}
+
 		ok, _, err := procReadConsoleInput.Call(uintptr(s.handle),
 			uintptr(unsafe.Pointer(&input)), 1, uintptr(unsafe.Pointer(&rv)))
 
@@ -187,8 +303,6 @@ func (s *State) readNext() (interface{}, error) {
 		}
 
 		if input.eventType == window_buffer_size_event {
-			xy := (*coord)(unsafe.Pointer(&input.blob[0]))
-			s.columns = int(xy.x)
 			return winch, nil
 		}
 		if input.eventType != key_event {
@@ -307,26 +421,72 @@ func (s *State) readNext() (interface{}, error) {
 	}
 }
 
-// Close returns the terminal to its previous mode
+// Close returns the terminal to its previous mode. If a prompt of a
+// session was cancelled (see PromptContext), Close also stops the read of
+// the input that it left waiting, if the input supports read deadlines.
+// Otherwise that read takes the next keystroke.
 func (s *State) Close() error {
-	s.origMode.ApplyMode()
-	return nil
+	if s.session {
+		s.stopReading()
+		return s.closeHistory()
+	}
+	if !s.inputRedirected {
+		setConsoleMode(s.handle, s.origMode)
+	}
+	if !s.noStyles && !s.ansiOutput {
+		setConsoleMode(s.hOut, s.origOutMode)
+	}
+	return s.closeHistory()
 }
 
 func (s *State) startPrompt() {
-	if m, err := TerminalMode(); err == nil {
-		s.defaultMode = m.(inputMode)
+	if s.session {
+		s.setBracketedPaste(true)
+		s.startReading()
+		return
+	}
+	if m, err := getConsoleMode(s.handle); err == nil {
+		s.defaultMode = m
 		mode := s.defaultMode
 		mode &^= enableProcessedInput
-		mode.ApplyMode()
+		setConsoleMode(s.handle, mode)
 	}
 }
 
 func (s *State) restartPrompt() {
+	if s.session {
+		s.startReading()
+	}
 }
 
 func (s *State) stopPrompt() {
-	s.defaultMode.ApplyMode()
+	if s.session {
+		s.setBracketedPaste(false)
+		return
+	}
+	setConsoleMode(s.handle, s.defaultMode)
+}
+
+func (s *State) terminalMode() (ModeApplier, error) {
+	m, err := getConsoleMode(s.handle)
+	if err != nil {
+		return nil, err
+	}
+	return consoleMode{s.handle, m}, nil
+}
+
+func (s *State) applyOrigMode() {
+	setConsoleMode(s.handle, s.origMode)
+}
+
+// consoleMode is an input mode that applies to a particular console handle
+type consoleMode struct {
+	handle syscall.Handle
+	mode   inputMode
+}
+
+func (m consoleMode) ApplyMode() error {
+	return setConsoleMode(m.handle, m.mode)
 }
 
 // TerminalSupported returns true because line editing is always
@@ -340,11 +500,7 @@ func (mode inputMode) ApplyMode() error {
 	if hIn == invalid_handle_value || hIn == 0 {
 		return err
 	}
-	ok, _, err := procSetConsoleMode.Call(hIn, uintptr(mode))
-	if ok != 0 {
-		err = nil
-	}
-	return err
+	return setConsoleMode(syscall.Handle(hIn), mode)
 }
 
 // TerminalMode returns the current terminal input mode as an InputModeSetter.
@@ -352,16 +508,28 @@ func (mode inputMode) ApplyMode() error {
 // This function is provided for convenience, and should
 // not be necessary for most users of liner.
 func TerminalMode() (ModeApplier, error) {
-	var mode inputMode
 	hIn, _, err := procGetStdHandle.Call(uintptr(std_input_handle))
 	if hIn == invalid_handle_value || hIn == 0 {
 		return nil, err
 	}
-	ok, _, err := procGetConsoleMode.Call(hIn, uintptr(unsafe.Pointer(&mode)))
+	return getConsoleMode(syscall.Handle(hIn))
+}
+
+func getConsoleMode(handle syscall.Handle) (inputMode, error) {
+	var mode inputMode
+	ok, _, err := procGetConsoleMode.Call(uintptr(handle), uintptr(unsafe.Pointer(&mode)))
 	if ok != 0 {
 		err = nil
 	}
 	return mode, err
 }
 
+func setConsoleMode(handle syscall.Handle, mode inputMode) error {
+	ok, _, err := procSetConsoleMode.Call(uintptr(handle), uintptr(mode))
+	if ok != 0 {
+		err = nil
+	}
+	return err
+}
+
 const cursorColumn = true
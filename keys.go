@@ -0,0 +1,266 @@
+package liner
+
+type action int
+
+const (
+	left action = iota
+	right
+	up
+	down
+	home
+	end
+	insert
+	del
+	pageUp
+	pageDown
+	f1
+	f2
+	f3
+	f4
+	f5
+	f6
+	f7
+	f8
+	f9
+	f10
+	f11
+	f12
+	altB
+	altBs // Alt+Backspace
+	altD
+	altF
+	altY
+	shiftTab
+	wordLeft
+	wordRight
+	winch
+	unknown
+	redo
+)
+
+const (
+	ctrlA          = 1
+	ctrlB          = 2
+	ctrlC          = 3
+	ctrlD          = 4
+	ctrlE          = 5
+	ctrlF          = 6
+	ctrlG          = 7
+	ctrlH          = 8
+	tab            = 9
+	lf             = 10
+	ctrlK          = 11
+	ctrlL          = 12
+	cr             = 13
+	ctrlN          = 14
+	ctrlO          = 15
+	ctrlP          = 16
+	ctrlQ          = 17
+	ctrlR          = 18
+	ctrlS          = 19
+	ctrlT          = 20
+	ctrlU          = 21
+	ctrlV          = 22
+	ctrlW          = 23
+	ctrlX          = 24
+	ctrlY          = 25
+	ctrlZ          = 26
+	esc            = 27
+	ctrlUnderscore = 31
+	bs             = 127
+)
+
+// bracketedPaste is text that the terminal reported as pasted, rather than
+// typed
+type bracketedPaste []rune
+
+// Key identifies a keystroke that can be bound to a Command with Bind. A
+// Key holding a rune, such as Key('x') or KeyCtrlX, stands for the key that
+// types that rune. Keys without a rune, such as KeyUp or KeyF1, are negative.
+type Key rune
+
+// Keys that are not written as a Key of a printable rune.
+const (
+	KeyCtrlA          Key = ctrlA
+	KeyCtrlB          Key = ctrlB
+	KeyCtrlC          Key = ctrlC
+	KeyCtrlD          Key = ctrlD
+	KeyCtrlE          Key = ctrlE
+	KeyCtrlF          Key = ctrlF
+	KeyCtrlG          Key = ctrlG
+	KeyCtrlH          Key = ctrlH
+	KeyTab            Key = tab
+	KeyCtrlJ          Key = lf
+	KeyCtrlK          Key = ctrlK
+	KeyCtrlL          Key = ctrlL
+	KeyEnter          Key = cr
+	KeyCtrlN          Key = ctrlN
+	KeyCtrlO          Key = ctrlO
+	KeyCtrlP          Key = ctrlP
+	KeyCtrlQ          Key = ctrlQ
+	KeyCtrlR          Key = ctrlR
+	KeyCtrlS          Key = ctrlS
+	KeyCtrlT          Key = ctrlT
+	KeyCtrlU          Key = ctrlU
+	KeyCtrlV          Key = ctrlV
+	KeyCtrlW          Key = ctrlW
+	KeyCtrlX          Key = ctrlX
+	KeyCtrlY          Key = ctrlY
+	KeyCtrlZ          Key = ctrlZ
+	KeyEsc            Key = esc
+	KeyCtrlUnderscore Key = ctrlUnderscore
+	KeyBackspace      Key = bs
+	KeyLeft           Key = -1 - Key(left)
+	KeyRight          Key = -1 - Key(right)
+	KeyUp             Key = -1 - Key(up)
+	KeyDown           Key = -1 - Key(down)
+	KeyHome           Key = -1 - Key(home)
+	KeyEnd            Key = -1 - Key(end)
+	KeyInsert         Key = -1 - Key(insert)
+	KeyDelete         Key = -1 - Key(del)
+	KeyPageUp         Key = -1 - Key(pageUp)
+	KeyPageDown       Key = -1 - Key(pageDown)
+	KeyF1             Key = -1 - Key(f1)
+	KeyF2             Key = -1 - Key(f2)
+	KeyF3             Key = -1 - Key(f3)
+	KeyF4             Key = -1 - Key(f4)
+	KeyF5             Key = -1 - Key(f5)
+	KeyF6             Key = -1 - Key(f6)
+	KeyF7             Key = -1 - Key(f7)
+	KeyF8             Key = -1 - Key(f8)
+	KeyF9             Key = -1 - Key(f9)
+	KeyF10            Key = -1 - Key(f10)
+	KeyF11            Key = -1 - Key(f11)
+	KeyF12            Key = -1 - Key(f12)
+	KeyAltB           Key = -1 - Key(altB)
+	KeyAltBackspace   Key = -1 - Key(altBs)
+	KeyAltD           Key = -1 - Key(altD)
+	KeyAltF           Key = -1 - Key(altF)
+	KeyAltY           Key = -1 - Key(altY)
+	KeyShiftTab       Key = -1 - Key(shiftTab)
+	KeyCtrlLeft       Key = -1 - Key(wordLeft)
+	KeyCtrlRight      Key = -1 - Key(wordRight)
+)
+
+// keyOf returns the Key for an item returned by readNext
+func keyOf(next interface{}) (Key, bool) {
+	switch v := next.(type) {
+	case rune:
+		return Key(v), true
+	case action:
+		if v >= winch {
+			// Not a keystroke
+			return 0, false
+		}
+		return -1 - Key(v), true
+	}
+	return 0, false
+}
+
+// input returns the item that readNext returns when k is pressed
+func (k Key) input() interface{} {
+	if k < 0 {
+		return action(-1 - k)
+	}
+	return rune(k)
+}
+
+// Command is an editing operation that a key can be bound to with Bind.
+// The built-in commands below are the default bindings of their keys;
+// applications can supply their own commands with CommandFunc.
+type Command interface {
+	// input returns the item that the editor handles in place of the
+	// key that is bound to the command
+	input() interface{}
+}
+
+// builtinCommand is the default behaviour of a key
+type builtinCommand Key
+
+func (b builtinCommand) input() interface{} {
+	return Key(b).input()
+}
+
+// The built-in commands, named after their GNU Readline counterparts.
+const (
+	AcceptLine         = builtinCommand(cr)             // Enter
+	BeginningOfLine    = builtinCommand(ctrlA)          // Ctrl-A, Home
+	EndOfLine          = builtinCommand(ctrlE)          // Ctrl-E, End
+	BackwardChar       = builtinCommand(ctrlB)          // Ctrl-B, Left
+	ForwardChar        = builtinCommand(ctrlF)          // Ctrl-F, Right
+	BackwardWord       = builtinCommand(KeyAltB)        // Alt-B, Ctrl-Left
+	ForwardWord        = builtinCommand(KeyAltF)        // Alt-F, Ctrl-Right
+	DeleteChar         = builtinCommand(KeyDelete)      // Del
+	DeleteCharOrEOF    = builtinCommand(ctrlD)          // Ctrl-D
+	BackwardDeleteChar = builtinCommand(bs)             // Backspace, Ctrl-H
+	Interrupt          = builtinCommand(ctrlC)          // Ctrl-C
+	ClearScreen        = builtinCommand(ctrlL)          // Ctrl-L
+	TransposeChars     = builtinCommand(ctrlT)          // Ctrl-T
+	KillLine           = builtinCommand(ctrlK)          // Ctrl-K
+	UnixLineDiscard    = builtinCommand(ctrlU)          // Ctrl-U
+	UnixWordRubout     = builtinCommand(ctrlW)          // Ctrl-W, Alt-Backspace
+	KillWord           = builtinCommand(KeyAltD)        // Alt-D
+	PreviousHistory    = builtinCommand(ctrlP)          // Ctrl-P, Up
+	NextHistory        = builtinCommand(ctrlN)          // Ctrl-N, Down
+	ReverseSearch      = builtinCommand(ctrlR)          // Ctrl-R
+	Yank               = builtinCommand(ctrlY)          // Ctrl-Y
+	Complete           = builtinCommand(tab)            // Tab
+	Undo               = builtinCommand(ctrlUnderscore) // Ctrl-_, Ctrl-X Ctrl-U
+	Redo               = builtinCommand(-1 - Key(redo)) // not bound by default
+)
+
+// CommandFunc is a Command implemented by the application. It is passed the
+// line being edited and the cursor position (in runes), and returns the new
+// line and cursor position. The line may be modified in place.
+type CommandFunc func(line []rune, pos int) ([]rune, int)
+
+func (f CommandFunc) input() interface{} {
+	return f
+}
+
+// Bind makes Prompt run cmd when key is pressed, in place of the key's
+// default behaviour. Passing a nil cmd restores the default behaviour.
+//
+// Keys pressed during tab completion, reverse search and yanking keep their
+// meaning there (for example, Tab always selects the next completion), and
+// bindings have no effect on PasswordPrompt.
+//
+// By default, Ctrl-X starts the two-key Ctrl-X Ctrl-U for Undo. Binding
+// Ctrl-X replaces that sequence, and leaves Ctrl-_ for Undo.
+func (s *State) Bind(key Key, cmd Command) {
+	if cmd == nil {
+		delete(s.keymap, key)
+		return
+	}
+	if s.keymap == nil {
+		s.keymap = make(map[Key]Command)
+	}
+	s.keymap[key] = cmd
+}
+
+// Unbind makes Prompt ignore key, for example to disable the default
+// Ctrl-L (clear screen) or Ctrl-T (transpose) behaviour. Unbinding Ctrl-X
+// disables Ctrl-X Ctrl-U (undo).
+func (s *State) Unbind(key Key) {
+	if s.keymap == nil {
+		s.keymap = make(map[Key]Command)
+	}
+	s.keymap[key] = nil
+}
+
+// boundInput returns the item the editor should handle when next is read,
+// and whether that differs from next because of Bind or Unbind
+func (s *State) boundInput(next interface{}) (interface{}, bool) {
+	k, ok := keyOf(next)
+	if !ok {
+		return next, false
+	}
+	cmd, ok := s.keymap[k]
+	if !ok {
+		return next, false
+	}
+	if cmd == nil {
+		return nil, true
+	}
+	return cmd.input(), true
+}
@@ -0,0 +1,30 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBind(t *testing.T) {
+	s := newTestSession(t, 80)
+	s.Bind(KeyCtrlX, BeginningOfLine)
+	s.Bind(KeyF1, CommandFunc(func(line []rune, pos int) ([]rune, int) {
+		return []rune(strings.ToUpper(string(line))), 0
+	}))
+	s.Bind(KeyCtrlD, EndOfLine)
+	s.Unbind(KeyCtrlU)
+
+	if line := promptWith(t, s, "bc\x18a\x1bOP\x04\x15d\r"); line != "ABCd" {
+		t.Fatalf("Expected \"ABCd\", got %q", line)
+	}
+
+	s.Bind(KeyCtrlD, nil)
+	s.send("\x04")
+	if _, err := s.Prompt("> "); err != io.EOF {
+		t.Fatalf("Expected io.EOF after restoring Ctrl-D, got %v", err)
+	}
+}
@@ -6,83 +6,15 @@ package liner
 import (
 	"bufio"
 	"container/ring"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
-type action int
-
-const (
-	left action = iota
-	right
-	up
-	down
-	home
-	end
-	insert
-	del
-	pageUp
-	pageDown
-	f1
-	f2
-	f3
-	f4
-	f5
-	f6
-	f7
-	f8
-	f9
-	f10
-	f11
-	f12
-	altB
-	altBs // Alt+Backspace
-	altD
-	altF
-	altY
-	shiftTab
-	wordLeft
-	wordRight
-	winch
-	unknown
-)
-
-const (
-	ctrlA = 1
-	ctrlB = 2
-	ctrlC = 3
-	ctrlD = 4
-	ctrlE = 5
-	ctrlF = 6
-	ctrlG = 7
-	ctrlH = 8
-	tab   = 9
-	lf    = 10
-	ctrlK = 11
-	ctrlL = 12
-	cr    = 13
-	ctrlN = 14
-	ctrlO = 15
-	ctrlP = 16
-	ctrlQ = 17
-	ctrlR = 18
-	ctrlS = 19
-	ctrlT = 20
-	ctrlU = 21
-	ctrlV = 22
-	ctrlW = 23
-	ctrlX = 24
-	ctrlY = 25
-	ctrlZ = 26
-	esc   = 27
-	bs    = 127
-)
-
 const (
 	beep = "\a"
 )
@@ -94,25 +26,238 @@ const (
 	tabReverse
 )
 
+// readNext waits for the next keystroke or event. While a prompt is waiting,
+// other goroutines may print above it (see Write).
+func (s *State) readNext() (interface{}, error) {
+	if s.prompting {
+		s.outputMutex.Unlock()
+	}
+	next, err := s.readInput()
+	if s.prompting {
+		s.outputMutex.Lock()
+	}
+	if next == winch {
+		s.getColumns()
+	}
+	return next, err
+}
+
+// setShown records what is displayed on the prompt row(s), so that the
+// prompt can be drawn again after Write.
+func (s *State) setShown(prompt []rune, buf []rune, pos int) {
+	s.shownPrompt = prompt
+	s.shownLine = append(s.shownLine[:0], buf...)
+	s.shownPos = pos
+}
+
 func (s *State) refresh(prompt []rune, buf []rune, pos int) error {
 	if s.columns == 0 {
 		return ErrInternal
 	}
 
 	s.needRefresh = false
+	s.setShown(prompt, buf, pos)
+	s.clearBelow()
+	var err error
 	if s.multiLineMode {
-		return s.refreshMultiLine(prompt, buf, pos)
+		err = s.refreshMultiLine(prompt, buf, pos)
+	} else {
+		// The rows of the prompt above the line are not redrawn
+		_, last := splitPrompt(prompt)
+		err = s.refreshSingleLine(last, buf, pos)
+	}
+	if err != nil {
+		return err
 	}
-	return s.refreshSingleLine(prompt, buf, pos)
+	return s.printBelow(buf, pos)
+}
+
+// belowOffset returns the number of rows from the cursor down to the first
+// row below the prompt
+func (s *State) belowOffset() int {
+	if !s.multiLineMode {
+		return 1
+	}
+	cursorRows := s.cursorRows
+	if cursorRows == 0 {
+		cursorRows = 1
+	}
+	return s.maxRows - cursorRows + 1
+}
+
+// statusText returns the status line (see SetStatusLine) for buf, cut to
+// fit on one row, or nil if there is none.
+func (s *State) statusText(buf []rune, pos int) []rune {
+	if s.statusFunc == nil {
+		return nil
+	}
+	text := []rune(s.statusFunc(string(buf), pos))
+	for i, r := range text {
+		if r == '\n' {
+			text = text[:i]
+			break
+		}
+	}
+	if !validPrompt(text) {
+		return nil
+	}
+	if s.noStyles {
+		text = stripSGR(text)
+	}
+	if countGlyphs(text) > s.columns-1 {
+		text = getPrefixColumns(stripSGR(text), s.columns-1)
+	}
+	return text
+}
+
+// printBelow displays the status line for buf and the completion menu, if
+// any, below the rows that the prompt uses, and leaves the cursor where it
+// was.
+func (s *State) printBelow(buf []rune, pos int) error {
+	if !s.prompting {
+		return nil
+	}
+	var rows [][]rune
+	if status := s.statusText(buf, pos); len(status) > 0 {
+		rows = append(rows, status)
+	}
+	rows = append(rows, s.menu...)
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// Newlines rather than moveDown, to scroll if the rows are below the
+	// bottom of the screen
+	offset := s.belowOffset()
+	if _, err := fmt.Fprint(s.w, strings.Repeat("\n", offset)); err != nil {
+		return err
+	}
+	for i, row := range rows {
+		if i > 0 {
+			if _, err := fmt.Fprint(s.w, "\n"); err != nil {
+				return err
+			}
+		}
+		s.cursorPos(0)
+		s.eraseLine()
+		if _, err := fmt.Fprint(s.w, string(row)); err != nil {
+			return err
+		}
+	}
+	s.moveUp(offset + len(rows) - 1)
+	s.cursorPos(s.cursorCol)
+	s.belowRows = len(rows)
+	return nil
+}
+
+// clearBelow removes the rows that printBelow displayed from the screen.
+func (s *State) clearBelow() {
+	if s.belowRows == 0 {
+		return
+	}
+	offset := s.belowOffset()
+	s.moveDown(offset)
+	for i := 0; i < s.belowRows; i++ {
+		if i > 0 {
+			s.moveDown(1)
+		}
+		s.cursorPos(0)
+		s.eraseLine()
+	}
+	s.moveUp(offset + s.belowRows - 1)
+	s.cursorPos(s.cursorCol)
+	s.belowRows = 0
+}
+
+// splitPrompt splits prompt after its last newline, into the rows that are
+// displayed above the edited line and the part that is displayed before it.
+func splitPrompt(prompt []rune) (header, last []rune) {
+	for i := len(prompt) - 1; i >= 0; i-- {
+		if prompt[i] == '\n' {
+			return prompt[:i+1], prompt[i+1:]
+		}
+	}
+	return nil, prompt
+}
+
+// rows returns the number of rows that n columns of multi-line output take
+// up, where full is as returned by multiLineGlyphs
+func (s *State) rows(n int, full bool) int {
+	if full {
+		return n / s.columns
+	}
+	return n/s.columns + 1
+}
+
+// printPrompt prints prompt on a new row, ready for editing.
+func (s *State) printPrompt(prompt []rune) {
+	s.rightPrompt = nil
+	if s.rightPromptFunc != nil {
+		if r := []rune(s.rightPromptFunc()); validPrompt(r) && !strings.ContainsRune(string(r), '\n') {
+			s.rightPrompt = r
+			if s.noStyles {
+				s.rightPrompt = stripSGR(r)
+			}
+		}
+	}
+	fmt.Fprint(s.w, string(prompt))
+	if s.multiLineMode && s.columns > 0 {
+		s.cursorRows = s.rows(multiLineGlyphs(prompt, s.columns, 0))
+		s.maxRows = s.cursorRows
+	}
+}
+
+// printHeader prints the rows of prompt that are displayed above the edited
+// line in single-line mode, which refresh does not draw. It is used when the
+// prompt is drawn again from scratch.
+func (s *State) printHeader(prompt []rune) {
+	if header, _ := splitPrompt(prompt); len(header) > 0 && !s.multiLineMode {
+		fmt.Fprint(s.w, string(header))
+	}
+}
+
+// withContinuation returns buf as it is displayed in multi-line mode, with
+// the continuation prompt after each newline, along with the styles (if any)
+// and the position of the displayed runes
+func (s *State) withContinuation(buf []rune, styles []string, pos int) ([]rune, []string, int) {
+	cont := []rune(s.continuationPrompt)
+	if s.noStyles {
+		cont = stripSGR(cont)
+	}
+	if len(cont) == 0 {
+		return buf, styles, pos
+	}
+	var text []rune
+	var textStyles []string
+	textPos := pos
+	for i, r := range buf {
+		text = append(text, r)
+		if styles != nil {
+			textStyles = append(textStyles, styles[i])
+		}
+		if r != '\n' {
+			continue
+		}
+		text = append(text, cont...)
+		if styles != nil {
+			textStyles = append(textStyles, make([]string, len(cont))...)
+		}
+		if i < pos {
+			textPos += len(cont)
+		}
+	}
+	return text, textStyles, textPos
 }
 
 func (s *State) refreshSingleLine(prompt []rune, buf []rune, pos int) error {
 	s.cursorPos(0)
-	_, err := fmt.Print(string(prompt))
+	_, err := fmt.Fprint(s.w, string(prompt))
 	if err != nil {
 		return err
 	}
 
+	ghost := s.ghost(buf, pos)
+	styles := s.highlight(buf)
 	pLen := countGlyphs(prompt)
 	bLen := countGlyphs(buf)
 	// on some OS / terminals extra column is needed to place the cursor char
@@ -121,9 +266,24 @@ func (s *State) refreshSingleLine(prompt []rune, buf []rune, pos int) error {
 	}
 	pos = countGlyphs(buf[:pos])
 	if pLen+bLen < s.columns {
-		_, err = fmt.Print(string(buf))
+		err = s.writeHighlighted(buf, styles)
+		used := pLen + countGlyphs(buf)
+		if len(ghost) > 0 && err == nil {
+			// Show as much of the suggestion as fits on the row
+			shown := getPrefixGlyphs(ghost, s.columns-1-used)
+			for i, r := range shown {
+				if r == '\n' {
+					shown = shown[:i]
+					break
+				}
+			}
+			err = s.writeStyled(string(shown), suggestStyle)
+			used += countGlyphs(shown)
+		}
 		s.eraseLine()
-		s.cursorPos(pLen + pos)
+		s.printRightPrompt(used)
+		s.cursorCol = pLen + pos
+		s.cursorPos(s.cursorCol)
 	} else {
 		// Find space available
 		space := s.columns - pLen
@@ -152,23 +312,30 @@ func (s *State) refreshSingleLine(prompt []rune, buf []rune, pos int) error {
 
 		// Output
 		if start > 0 {
-			fmt.Print("{")
+			fmt.Fprint(s.w, "{")
+		}
+		if styles != nil {
+			s.writeHighlighted(line, styles[startRune:startRune+len(line)])
+		} else {
+			fmt.Fprint(s.w, string(line))
 		}
-		fmt.Print(string(line))
 		if end < bLen {
-			fmt.Print("}")
+			fmt.Fprint(s.w, "}")
 		}
 
 		// Set cursor position
 		s.eraseLine()
-		s.cursorPos(pLen + pos)
+		s.cursorCol = pLen + pos
+		s.cursorPos(s.cursorCol)
 	}
 	return err
 }
 
 func (s *State) refreshMultiLine(prompt []rune, buf []rune, pos int) error {
+	ghost := s.ghost(buf, pos)
+	buf, styles, pos := s.withContinuation(buf, s.highlight(buf), pos)
 	promptColumns := countMultiLineGlyphs(prompt, s.columns, 0)
-	totalColumns := countMultiLineGlyphs(buf, s.columns, promptColumns)
+	totalColumns, full := multiLineGlyphs(append(buf[:len(buf):len(buf)], ghost...), s.columns, promptColumns)
 	// on some OS / terminals extra column is needed to place the cursor char
 	// if cursorColumn {
 	//	totalColumns++
@@ -177,7 +344,7 @@ func (s *State) refreshMultiLine(prompt []rune, buf []rune, pos int) error {
 	// it looks like Multiline mode always assume that a cursor need an extra column,
 	// and always emit a newline if we are at the screen end, so no worarounds needed there
 
-	totalRows := (totalColumns + s.columns - 1) / s.columns
+	totalRows := s.rows(totalColumns, full)
 	maxRows := s.maxRows
 	if totalRows > s.maxRows {
 		s.maxRows = totalRows
@@ -205,17 +372,27 @@ func (s *State) refreshMultiLine(prompt []rune, buf []rune, pos int) error {
 	s.eraseLine()
 
 	/* Write the prompt and the current buffer content */
-	if _, err := fmt.Print(string(prompt)); err != nil {
+	if _, err := fmt.Fprint(s.w, string(prompt)); err != nil {
+		return err
+	}
+	if err := s.writeHighlighted(buf, styles); err != nil {
 		return err
 	}
-	if _, err := fmt.Print(string(buf)); err != nil {
+	if err := s.writeStyled(string(ghost), suggestStyle); err != nil {
 		return err
 	}
+	// The right prompt is only shown if the line fits on the row that the
+	// prompt ends on
+	header, _ := splitPrompt(prompt)
+	used := totalColumns - countMultiLineGlyphs(header, s.columns, 0)
+	if used < s.columns && !full && !strings.ContainsRune(string(buf)+string(ghost), '\n') {
+		s.printRightPrompt(used)
+	}
 
 	/* If we are at the very end of the screen with our prompt, we need to
 	 * emit a newline and move the prompt to the first column. */
 	cursorColumns := countMultiLineGlyphs(buf[:pos], s.columns, promptColumns)
-	if cursorColumns == totalColumns && totalColumns%s.columns == 0 {
+	if cursorColumns == totalColumns && full {
 		s.emitNewLine()
 		s.cursorPos(0)
 		totalRows++
@@ -230,20 +407,76 @@ func (s *State) refreshMultiLine(prompt []rune, buf []rune, pos int) error {
 		s.moveUp(totalRows - cursorRows)
 	}
 	/* Set column. */
-	s.cursorPos(cursorColumns % s.columns)
+	s.cursorCol = cursorColumns % s.columns
+	s.cursorPos(s.cursorCol)
 
 	s.cursorRows = cursorRows
 	return nil
 }
 
+// printTransient replaces the displayed prompt and line with the text that
+// the transient prompt function returns for line.
+func (s *State) printTransient(line string) {
+	text := []rune(s.transientPrompt(line))
+	if s.noStyles {
+		text = stripSGR(text)
+	}
+	s.erasePrompt()
+	fmt.Fprint(s.w, string(text))
+	s.maxRows = 1
+	s.cursorRows = 0
+}
+
+// printRightPrompt prints the right prompt (see SetRightPrompt) at the end of
+// the current row, if it fits after the used columns at its start.
+func (s *State) printRightPrompt(used int) {
+	width := countGlyphs(s.rightPrompt)
+	if len(s.rightPrompt) == 0 || used+1+width >= s.columns {
+		return
+	}
+	s.cursorPos(s.columns - 1 - width)
+	fmt.Fprint(s.w, string(s.rightPrompt))
+}
+
+// erasePrompt clears every row of the displayed prompt, and leaves the
+// cursor at the start of the first row.
+func (s *State) erasePrompt() {
+	s.clearBelow()
+	if s.multiLineMode {
+		cursorRows := s.cursorRows
+		if cursorRows == 0 {
+			cursorRows = 1
+		}
+		if s.maxRows-cursorRows > 0 {
+			s.moveDown(s.maxRows - cursorRows)
+		}
+		for i := 0; i < s.maxRows-1; i++ {
+			s.cursorPos(0)
+			s.eraseLine()
+			s.moveUp(1)
+		}
+		s.maxRows = 1
+		s.cursorRows = 1
+	}
+	s.cursorPos(0)
+	s.eraseLine()
+	if header, _ := splitPrompt(s.shownPrompt); len(header) > 0 && !s.multiLineMode {
+		for i := s.rows(multiLineGlyphs(header, s.columns, 0)) - 1; i > 0; i-- {
+			s.moveUp(1)
+			s.eraseLine()
+		}
+	}
+}
+
 func (s *State) resetMultiLine(prompt []rune, buf []rune, pos int) {
+	buf, _, pos = s.withContinuation(buf, nil, pos)
 	columns := countMultiLineGlyphs(prompt, s.columns, 0)
 	columns = countMultiLineGlyphs(buf[:pos], s.columns, columns)
 	columns += 2 // ^C
 	cursorRows := (columns + s.columns) / s.columns
 	if s.maxRows-cursorRows > 0 {
 		for i := 0; i < s.maxRows-cursorRows; i++ {
-			fmt.Println() // always moves the cursor down or scrolls the window up as needed
+			fmt.Fprintln(s.w) // always moves the cursor down or scrolls the window up as needed
 		}
 	}
 	s.maxRows = 1
@@ -266,7 +499,7 @@ func longestCommonPrefix(strs []string) string {
 	return longest
 }
 
-func (s *State) circularTabs(items []string) func(tabDirection) (string, error) {
+func (s *State) circularTabs(items []Candidate) func(tabDirection) (string, error) {
 	item := -1
 	return func(direction tabDirection) (string, error) {
 		if direction == tabForward {
@@ -282,7 +515,8 @@ func (s *State) circularTabs(items []string) func(tabDirection) (string, error)
 				item = len(items) - 1
 			}
 		}
-		return items[item], nil
+		s.menu = s.candidateInfo(items[item])
+		return items[item].chosen(), nil
 	}
 }
 
@@ -309,17 +543,18 @@ func calculateColumns(screenWidth int, items []string) (numColumns, numRows, max
 	return
 }
 
-func (s *State) printedTabs(items []string) func(tabDirection) (string, error) {
+func (s *State) printedTabs(p []rune, word []rune, items []Candidate) func(tabDirection) (string, error) {
 	numTabs := 1
-	prefix := longestCommonPrefix(items)
+	prefix := s.keepWord(longestCommonPrefix(candidateTexts(items)), word)
 	return func(direction tabDirection) (string, error) {
 		if len(items) == 1 {
-			return items[0], nil
+			return items[0].chosen(), nil
 		}
 
 		if numTabs == 2 {
+			s.clearBelow()
 			if len(items) > 100 {
-				fmt.Printf("\nDisplay all %d possibilities? (y or n) ", len(items))
+				fmt.Fprintf(s.w, "\nDisplay all %d possibilities? (y or n) ", len(items))
 			prompt:
 				for {
 					next, err := s.readNext()
@@ -339,22 +574,40 @@ func (s *State) printedTabs(items []string) func(tabDirection) (string, error) {
 					}
 				}
 			}
-			fmt.Println("")
-
-			numColumns, numRows, maxWidth := calculateColumns(s.columns, items)
+			fmt.Fprintln(s.w, "")
 
-			for i := 0; i < numRows; i++ {
-				for j := 0; j < numColumns*numRows; j += numRows {
-					if i+j < len(items) {
-						if maxWidth > 0 {
-							fmt.Printf("%-*.[1]*s", maxWidth, items[i+j])
-						} else {
-							fmt.Printf("%v ", items[i+j])
+			width, displayWidth := s.candidateWidths(items)
+			for _, group := range candidateGroups(items) {
+				if group[0].Group != "" {
+					fmt.Fprintln(s.w, s.candidateHeading(group[0].Group))
+				}
+				if describes(items) {
+					for _, c := range group {
+						fmt.Fprintln(s.w, s.candidateCell(c, width, displayWidth, false))
+					}
+					continue
+				}
+				displays := candidateDisplays(group)
+				numColumns, numRows, maxWidth := calculateColumns(s.columns, displays)
+
+				for i := 0; i < numRows; i++ {
+					for j := 0; j < numColumns*numRows; j += numRows {
+						if i+j < len(displays) {
+							item := []rune(displays[i+j])
+							if maxWidth > 0 {
+								if len(item) > maxWidth {
+									item = item[:maxWidth]
+								}
+								fmt.Fprint(s.w, s.highlightMatches(group[i+j], item), strings.Repeat(" ", maxWidth-len(item)))
+							} else {
+								fmt.Fprint(s.w, s.highlightMatches(group[i+j], item), " ")
+							}
 						}
 					}
+					fmt.Fprintln(s.w, "")
 				}
-				fmt.Println("")
 			}
+			s.printHeader(p)
 		} else {
 			numTabs++
 		}
@@ -363,23 +616,52 @@ func (s *State) printedTabs(items []string) func(tabDirection) (string, error) {
 }
 
 func (s *State) tabComplete(p []rune, line []rune, pos int) ([]rune, int, interface{}, error) {
-	if s.completer == nil {
+	var head, tail string
+	var list []Candidate
+	switch {
+	case s.contextCompleter != nil:
+		c, next, err := s.completeAsync(p, line, pos)
+		if err != nil {
+			return line, pos, rune(esc), err
+		}
+		if next != nil {
+			// A key was pressed before the completer returned
+			return line, pos, next, nil
+		}
+		head, list, tail = c.head, c.list, c.tail
+	case s.completer != nil:
+		head, list, tail = s.completer(string(line), pos)
+	default:
 		return line, pos, rune(esc), nil
 	}
-	head, list, tail := s.completer(string(line), pos)
+	hl := utf8.RuneCountInString(head)
+	var word []rune
+	if hl <= pos && pos <= len(line) {
+		word = line[hl:pos]
+	}
+	if s.matching != MatchNone {
+		list = s.matchCandidates(word, list)
+		defer func() { s.matched = nil }()
+	}
 	if len(list) <= 0 {
 		return line, pos, rune(esc), nil
 	}
-	hl := utf8.RuneCountInString(head)
 	if len(list) == 1 {
-		err := s.refresh(p, []rune(head+list[0]+tail), hl+utf8.RuneCountInString(list[0]))
-		return []rune(head + list[0] + tail), hl + utf8.RuneCountInString(list[0]), rune(esc), err
+		pick := list[0].chosen()
+		err := s.refresh(p, []rune(head+pick+tail), hl+utf8.RuneCountInString(pick))
+		return []rune(head + pick + tail), hl + utf8.RuneCountInString(pick), rune(esc), err
 	}
+	list = groupCandidates(list)
 
+	if s.tabStyle == TabMenu {
+		return s.menuComplete(p, line, pos, head, list, tail)
+	}
+
+	defer func() { s.menu = nil }()
 	direction := tabForward
 	tabPrinter := s.circularTabs(list)
 	if s.tabStyle == TabPrints {
-		tabPrinter = s.printedTabs(list)
+		tabPrinter = s.printedTabs(p, word, list)
 	}
 
 	for {
@@ -402,15 +684,25 @@ func (s *State) tabComplete(p []rune, line []rune, pos int) ([]rune, int, interf
 				continue
 			}
 			if key == esc {
-				return line, pos, rune(esc), nil
+				return s.endCompletion(p, line, pos, rune(esc))
 			}
 		}
 		if a, ok := next.(action); ok && a == shiftTab {
 			direction = tabReverse
 			continue
 		}
-		return []rune(head + pick + tail), hl + utf8.RuneCountInString(pick), next, nil
+		return s.endCompletion(p, []rune(head+pick+tail), hl+utf8.RuneCountInString(pick), next)
+	}
+}
+
+// endCompletion erases the details of the candidate that circularTabs
+// displays below the prompt, if any, once completion ends.
+func (s *State) endCompletion(p []rune, line []rune, pos int, next interface{}) ([]rune, int, interface{}, error) {
+	if s.menu == nil {
+		return line, pos, next, nil
 	}
+	s.menu = nil
+	return line, pos, next, s.refresh(p, line, pos)
 }
 
 // reverse intelligent search, implements a bash-like history search.
@@ -512,6 +804,30 @@ func (s *State) reverseISearch(origLine []rune, origPos int) ([]rune, int, inter
 	}
 }
 
+// pasteText returns the text of a paste as it is to be inserted: line breaks
+// are kept or replaced by spaces (see SetPasteNewlines), tabs are replaced by
+// spaces, and other control characters are dropped.
+func (s *State) pasteText(paste bracketedPaste) []rune {
+	text := make([]rune, 0, len(paste))
+	for i, r := range paste {
+		switch {
+		case r == '\n' && i > 0 && paste[i-1] == '\r':
+			// Second half of a CRLF
+		case r == '\r' || r == '\n':
+			if s.pasteNewlines && s.multiLineMode {
+				text = append(text, '\n')
+			} else {
+				text = append(text, ' ')
+			}
+		case r == tab:
+			text = append(text, ' ')
+		case !unicode.IsControl(r):
+			text = append(text, r)
+		}
+	}
+	return text
+}
+
 // addToKillRing adds some text to the kill ring. If mode is 0 it adds it to a
 // new node in the end of the kill ring, and move the current pointer to the new
 // node. If mode is 1 or 2 it appends or prepends the text to the current entry
@@ -592,25 +908,60 @@ func (s *State) yank(p []rune, text []rune, pos int) ([]rune, int, interface{},
 // Prompt displays p and returns a line of user input, not including a trailing
 // newline character. An io.EOF error is returned if the user signals end-of-file
 // by pressing Ctrl-D. Prompt allows line editing if the terminal supports it.
+// The prompt may be styled with SGR escape sequences (see ErrInvalidPrompt).
 func (s *State) Prompt(prompt string) (string, error) {
 	return s.PromptWithSuggestion(prompt, "", 0)
 }
 
+// PromptContext displays prompt and returns a line of user input, like
+// Prompt. If ctx is cancelled or its deadline passes before the line is
+// complete, PromptContext restores the terminal and returns ctx.Err().
+// Keystrokes typed after that are kept for the next prompt. To keep them,
+// the input of a terminal other than the Windows console is still read
+// after PromptContext returns. Close stops that read if the input supports
+// read deadlines, as pipes and network connections do; otherwise the read
+// takes one more keystroke.
+//
+// Input that is not a terminal is read without line editing, and such a
+// read cannot be interrupted once it has started.
+func (s *State) PromptContext(ctx context.Context, prompt string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	s.ctx = ctx
+	defer func() { s.ctx = nil }()
+	return s.PromptWithSuggestion(prompt, "", 0)
+}
+
+// validPrompt reports whether prompt contains only printable runes,
+// newlines and SGR escape sequences
+func validPrompt(prompt []rune) bool {
+	for i := 0; i < len(prompt); i++ {
+		if n := sgrLen(prompt[i:]); n > 0 {
+			i += n - 1
+			continue
+		}
+		if prompt[i] != '\n' && unicode.Is(unicode.C, prompt[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // PromptWithSuggestion displays prompt and an editable text with cursor at
 // given position. The cursor will be set to the end of the line if given position
 // is negative or greater than length of text (in runes). Returns a line of user input, not
 // including a trailing newline character. An io.EOF error is returned if the user
 // signals end-of-file by pressing Ctrl-D.
 func (s *State) PromptWithSuggestion(prompt string, text string, pos int) (string, error) {
-	for _, r := range prompt {
-		if unicode.Is(unicode.C, r) {
-			return "", ErrInvalidPrompt
-		}
+	if !validPrompt([]rune(prompt)) || !validPrompt([]rune(s.continuationPrompt)) ||
+		strings.ContainsRune(s.continuationPrompt, '\n') {
+		return "", ErrInvalidPrompt
 	}
 	if s.inputRedirected || !s.terminalSupported {
 		return s.promptUnsupported(prompt)
 	}
-	p := []rune(prompt)
+	_, p := splitPrompt([]rune(prompt))
 	const minWorkingSpace = 10
 	if s.columns < countGlyphs(p)+minWorkingSpace {
 		return s.tooNarrow(prompt)
@@ -622,7 +973,17 @@ func (s *State) PromptWithSuggestion(prompt string, text string, pos int) (strin
 	s.historyMutex.RLock()
 	defer s.historyMutex.RUnlock()
 
-	fmt.Print(prompt)
+	s.outputMutex.Lock()
+	s.prompting = true
+	defer func() {
+		s.prompting = false
+		s.rightPrompt = nil
+		s.outputMutex.Unlock()
+	}()
+
+	var vi viState
+	p = s.viPrompt(prompt, &vi)
+	s.printPrompt(p)
 	var line = []rune(text)
 	historyEnd := ""
 	var historyPrefix []string
@@ -630,13 +991,20 @@ func (s *State) PromptWithSuggestion(prompt string, text string, pos int) (strin
 	historyStale := true
 	historyAction := false // used to mark history related actions
 	killAction := 0        // used to mark kill related actions
+	edit := otherEdit      // the kind of change the last key made
 
 	defer s.stopPrompt()
 
 	if pos < 0 || len(line) < pos {
 		pos = len(line)
 	}
-	if len(line) > 0 {
+	edits := editHistory{prev: snapshot(line, pos)}
+	s.suggested = false
+	s.belowRows = 0
+	s.updateSuggestion(line, false)
+	s.setShown(p, nil, 0)
+	// The right prompt and the status line are only drawn by refresh
+	if len(line) > 0 || s.rightPrompt != nil || s.statusFunc != nil {
 		err := s.refresh(p, line, pos)
 		if err != nil {
 			return "", err
@@ -652,33 +1020,118 @@ mainLoop:
 		next, err := s.readNext()
 	haveNext:
 		if err != nil {
+			if s.cancelled(err) {
+				// Leave the abandoned line on screen, like Ctrl-C does
+				s.dropSuggestion(p, line, pos)
+				s.clearBelow()
+				if s.multiLineMode {
+					s.resetMultiLine(p, line, pos)
+				}
+				fmt.Fprintln(s.w)
+				return "", err
+			}
 			if s.shouldRestart != nil && s.shouldRestart(err) {
 				goto restart
 			}
 			return "", err
 		}
 
+		if historyAction {
+			edit = historyEdit
+		}
+		edits.record(line, pos, edit)
+		edit = otherEdit
 		historyAction = false
+		if bound, ok := s.boundInput(next); ok {
+			next = bound
+			// The key may be one that shuts down the rune reader
+			// without ending the prompt any more
+			s.restartPrompt()
+		}
+		if s.editMode == ViMode {
+			if r, ok := next.(rune); ok && !vi.normal && r == esc {
+				pos = vi.escape(line, pos)
+				p = s.viPrompt(prompt, &vi)
+				s.needRefresh = true
+				next = nil
+			} else if ok && vi.normal && (r >= ' ' || r == ctrlH) {
+				if r == ctrlH || r == bs {
+					r = 'h'
+				}
+				line, pos, next, err = s.viCommand(&vi, line, pos, r)
+				p = s.viPrompt(prompt, &vi)
+				s.needRefresh = true
+				if err != nil || next != nil {
+					goto haveNext
+				}
+			}
+			if !vi.normal {
+				// Everything typed in insert mode is undone as one change
+				edit = insertEdit
+			}
+		}
+		if accepted, n, ok := s.acceptSuggestion(next, line, pos); ok {
+			line, pos = accepted, n
+			s.needRefresh = true
+			next = nil
+		}
+		// Up and Down move between the lines of a multi-line text, and
+		// through the history from its first and last lines
+		moved, ok := pos, false
+		switch next {
+		case rune(ctrlP), up:
+			moved, ok = lineUp(line, pos)
+		case rune(ctrlN), down:
+			moved, ok = lineDown(line, pos)
+		}
+		if ok {
+			pos = moved
+			historyAction = true // Leave the history search as it was
+			s.needRefresh = true
+			next = nil
+		}
 		switch v := next.(type) {
+		case CommandFunc:
+			line, pos = v(line, pos)
+			if pos < 0 {
+				pos = 0
+			} else if pos > len(line) {
+				pos = len(line)
+			}
+			s.needRefresh = true
+		case bracketedPaste:
+			text := s.pasteText(v)
+			line = append(line[:pos], append(text, line[pos:]...)...)
+			pos += len(text)
+			s.needRefresh = true
 		case rune:
 			switch v {
 			case cr, lf:
-				if s.needRefresh {
-					err := s.refresh(p, line, pos)
-					if err != nil {
-						return "", err
-					}
+				if s.isComplete != nil && !s.isComplete(string(line)) {
+					line = append(line[:pos], append([]rune{'\n'}, line[pos:]...)...)
+					pos++
+					edit = insertEdit
+					s.needRefresh = true
+					// The rune reader shuts down after a line break
+					s.restartPrompt()
+					break
 				}
-				if s.multiLineMode {
+				if err := s.dropSuggestion(p, line, pos); err != nil {
+					return "", err
+				}
+				s.clearBelow()
+				if s.transientPrompt != nil {
+					s.printTransient(string(line))
+				} else if s.multiLineMode {
 					s.resetMultiLine(p, line, pos)
 				}
-				fmt.Println()
+				fmt.Fprintln(s.w)
 				break mainLoop
 			case ctrlA: // Start of line
-				pos = 0
+				pos = lineStart(line, pos)
 				s.needRefresh = true
 			case ctrlE: // End of line
-				pos = len(line)
+				pos = lineEnd(line, pos)
 				s.needRefresh = true
 			case ctrlB: // left
 				if pos > 0 {
@@ -697,6 +1150,7 @@ mainLoop:
 			case ctrlD: // del
 				if pos == 0 && len(line) == 0 {
 					// exit
+					s.clearBelow()
 					return "", io.EOF
 				}
 
@@ -780,9 +1234,14 @@ mainLoop:
 				}
 			case ctrlL: // clear screen
 				s.eraseScreen()
+				s.printHeader(p)
 				s.needRefresh = true
 			case ctrlC: // reset
-				fmt.Println("^C")
+				if err := s.dropSuggestion(p, line, pos); err != nil {
+					return "", err
+				}
+				s.clearBelow()
+				fmt.Fprintln(s.w, "^C")
 				if s.multiLineMode {
 					s.resetMultiLine(p, line, pos)
 				}
@@ -791,7 +1250,11 @@ mainLoop:
 				}
 				line = line[:0]
 				pos = 0
-				fmt.Print(prompt)
+				edits = editHistory{}
+				vi = viState{}
+				p = s.viPrompt(prompt, &vi)
+				s.printPrompt(p)
+				s.setShown(p, line, pos)
 				s.restartPrompt()
 			case ctrlH, bs: // Backspace
 				if pos <= 0 {
@@ -825,22 +1288,38 @@ mainLoop:
 			case tab: // Tab completion
 				line, pos, next, err = s.tabComplete(p, line, pos)
 				goto haveNext
+			case ctrlUnderscore: // Undo
+				var ok bool
+				if line, pos, ok = edits.undoLast(line, pos); ok {
+					s.needRefresh = true
+				} else {
+					s.doBeep()
+				}
+			case ctrlX: // Prefix of Ctrl-X Ctrl-U, undo
+				next, err = s.readNext()
+				if err == nil && next == rune(ctrlU) {
+					next = rune(ctrlUnderscore)
+				}
+				goto haveNext
 			// Catch keys that do nothing, but you don't want them to beep
 			case esc:
 				// DO NOTHING
 			// Unused keys
-			case ctrlG, ctrlO, ctrlQ, ctrlS, ctrlV, ctrlX, ctrlZ:
+			case ctrlG, ctrlO, ctrlQ, ctrlS, ctrlV, ctrlZ:
 				fallthrough
 			// Catch unhandled control codes (anything <= 31)
-			case 0, 28, 29, 30, 31:
+			case 0, 28, 29, 30:
 				s.doBeep()
 			default:
-				if pos == len(line) && !s.multiLineMode &&
+				edit = insertEdit
+				if pos == len(line) && !s.multiLineMode && !s.suggesting() && s.highlighter == nil && s.statusFunc == nil &&
+					s.rightPrompt == nil &&
 					len(p)+len(line) < s.columns*4 && // Avoid countGlyphs on large lines
 					countGlyphs(p)+countGlyphs(line) < s.columns-1 {
 					line = append(line, v)
-					fmt.Printf("%c", v)
+					fmt.Fprintf(s.w, "%c", v)
 					pos++
+					s.setShown(p, line, pos)
 				} else {
 					line = append(line[:pos], append([]rune{v}, line[pos:]...)...)
 					pos++
@@ -946,9 +1425,9 @@ mainLoop:
 					s.doBeep()
 				}
 			case home: // Start of line
-				pos = 0
+				pos = lineStart(line, pos)
 			case end: // End of line
-				pos = len(line)
+				pos = lineEnd(line, pos)
 			case altD: // Delete next word
 				if pos == len(line) {
 					s.doBeep()
@@ -982,20 +1461,20 @@ mainLoop:
 				pos, line, killAction = s.eraseWord(pos, line, killAction)
 			case winch: // Window change
 				if s.multiLineMode {
-					if s.maxRows-s.cursorRows > 0 {
-						s.moveDown(s.maxRows - s.cursorRows)
-					}
-					for i := 0; i < s.maxRows-1; i++ {
-						s.cursorPos(0)
-						s.eraseLine()
-						s.moveUp(1)
-					}
-					s.maxRows = 1
-					s.cursorRows = 1
+					s.erasePrompt()
+				}
+			case redo:
+				var ok bool
+				if line, pos, ok = edits.redoLast(line, pos); !ok {
+					s.doBeep()
 				}
 			}
 			s.needRefresh = true
 		}
+		if vi.normal {
+			pos = viClamp(line, pos)
+		}
+		s.updateSuggestion(line, vi.normal)
 		if s.needRefresh && !s.inputWaiting() {
 			err := s.refresh(p, line, pos)
 			if err != nil {
@@ -1015,10 +1494,8 @@ mainLoop:
 // PasswordPrompt displays p, and then waits for user input. The input typed by
 // the user is not displayed in the terminal.
 func (s *State) PasswordPrompt(prompt string) (string, error) {
-	for _, r := range prompt {
-		if unicode.Is(unicode.C, r) {
-			return "", ErrInvalidPrompt
-		}
+	if !validPrompt([]rune(prompt)) {
+		return "", ErrInvalidPrompt
 	}
 	if !s.terminalSupported || s.columns == 0 {
 		return "", errors.New("liner: function not supported in this terminal")
@@ -1031,6 +1508,9 @@ func (s *State) PasswordPrompt(prompt string) (string, error) {
 	}
 
 	p := []rune(prompt)
+	if s.noStyles {
+		p = stripSGR(p)
+	}
 
 	defer s.stopPrompt()
 
@@ -1038,7 +1518,7 @@ restart:
 	s.startPrompt()
 	s.getColumns()
 
-	fmt.Print(prompt)
+	fmt.Fprint(s.w, string(p))
 	var line []rune
 	pos := 0
 
@@ -1053,10 +1533,19 @@ mainLoop:
 		}
 
 		switch v := next.(type) {
+		case bracketedPaste:
+			var text []rune
+			for _, r := range v {
+				if !unicode.IsControl(r) {
+					text = append(text, r)
+				}
+			}
+			line = append(line[:pos], append(text, line[pos:]...)...)
+			pos += len(text)
 		case rune:
 			switch v {
 			case cr, lf:
-				fmt.Println()
+				fmt.Fprintln(s.w)
 				break mainLoop
 			case ctrlD: // del
 				if pos == 0 && len(line) == 0 {
@@ -1069,6 +1558,7 @@ mainLoop:
 				s.restartPrompt()
 			case ctrlL: // clear screen
 				s.eraseScreen()
+				s.printHeader(p)
 				err := s.refresh(p, []rune{}, 0)
 				if err != nil {
 					return "", err
@@ -1082,13 +1572,13 @@ mainLoop:
 					pos -= n
 				}
 			case ctrlC:
-				fmt.Println("^C")
+				fmt.Fprintln(s.w, "^C")
 				if s.ctrlCAborts {
 					return "", ErrPromptAborted
 				}
 				line = line[:0]
 				pos = 0
-				fmt.Print(prompt)
+				fmt.Fprint(s.w, string(p))
 				s.restartPrompt()
 			// Unused keys
 			case esc, tab, ctrlA, ctrlB, ctrlE, ctrlF, ctrlG, ctrlK, ctrlN, ctrlO, ctrlP, ctrlQ, ctrlR, ctrlS,
@@ -1106,18 +1596,56 @@ mainLoop:
 	return string(line), nil
 }
 
+// Write prints p above the active prompt, so that output from other
+// goroutines does not corrupt the line being edited: the prompt is erased,
+// p is written (followed by a newline, if p does not end with one), and the
+// prompt, line and cursor are drawn again below it. When no prompt is active,
+// p is written to the output unchanged.
+//
+// Unlike the rest of liner's API, Write and Printf are safe to call from
+// another goroutine while Prompt is in progress.
+func (s *State) Write(p []byte) (int, error) {
+	s.outputMutex.Lock()
+	defer s.outputMutex.Unlock()
+
+	if !s.prompting {
+		return s.w.Write(p)
+	}
+	s.erasePrompt()
+	n, err := s.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if len(p) > 0 && p[len(p)-1] != '\n' {
+		fmt.Fprintln(s.w)
+	}
+	// Drawing the prompt again does not bring an out of date line up
+	// to date, so leave needRefresh alone
+	needRefresh := s.needRefresh
+	s.printHeader(s.shownPrompt)
+	err = s.refresh(s.shownPrompt, s.shownLine, s.shownPos)
+	s.needRefresh = needRefresh
+	return n, err
+}
+
+// Printf formats according to a format specifier and prints the result
+// above the active prompt, as Write does.
+func (s *State) Printf(format string, a ...interface{}) (int, error) {
+	return s.Write([]byte(fmt.Sprintf(format, a...)))
+}
+
 func (s *State) tooNarrow(prompt string) (string, error) {
 	// Docker and OpenWRT and etc sometimes return 0 column width
 	// Reset mode temporarily. Restore baked mode in case the terminal
 	// is wide enough for the next Prompt attempt.
-	m, merr := TerminalMode()
-	s.origMode.ApplyMode()
+	m, merr := s.terminalMode()
+	s.applyOrigMode()
 	if merr == nil {
 		defer m.ApplyMode()
 	}
 	if s.r == nil {
-		// Windows does not always set s.r
-		s.r = bufio.NewReader(os.Stdin)
+		// Windows does not set s.r for the console
+		s.r = bufio.NewReader(s.in)
 		defer func() { s.r = nil }()
 	}
 	return s.promptUnsupported(prompt)
@@ -1165,6 +1693,6 @@ func (s *State) eraseWord(pos int, line []rune, killAction int) (int, []rune, in
 
 func (s *State) doBeep() {
 	if !s.noBeep {
-		fmt.Print(beep)
+		fmt.Fprint(s.w, beep)
 	}
 }
@@ -3,8 +3,10 @@ package liner
 import (
 	"bytes"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestAppend(t *testing.T) {
@@ -99,6 +101,99 @@ dingle`
 	}
 }
 
+func TestHistoryEntries(t *testing.T) {
+	var s State
+	s.AppendHistory("plain")
+	s.AppendHistoryEntry(HistoryEntry{
+		Line: "make test", Time: time.Unix(1700000000, 0), Dir: "/home/me/my project",
+		Session: "tty1", Meta: map[string]string{"ticket": "ABC-1", "a=b": "c d"},
+	})
+	s.SetLastHistoryStatus(2)
+	s.AppendHistoryEntry(HistoryEntry{Line: "#1 not details", Time: time.Unix(1700000100, 0)})
+	s.AppendHistory("#1")
+
+	var out bytes.Buffer
+	num, err := s.WriteHistory(&out)
+	if err != nil || num != 4 {
+		t.Fatal("Unexpected result writing history", num, err)
+	}
+	written := "#liner-history v2\n" +
+		"plain\n" +
+		"#1700000000 dir=%2Fhome%2Fme%2Fmy+project status=2 session=tty1 +a%3Db=c+d +ticket=ABC-1\n" +
+		"make test\n" +
+		"#1700000100\n" +
+		"#1 not details\n" +
+		"#0\n" +
+		"#1\n"
+	if out.String() != written {
+		t.Fatalf("Expected %q, got %q", written, out.String())
+	}
+
+	var s2 State
+	num, err = s2.ReadHistory(strings.NewReader(written))
+	if err != nil || num != 4 {
+		t.Fatal("Unexpected result reading history", num, err)
+	}
+	entries := s2.HistoryEntries(nil)
+	if !reflect.DeepEqual(entries, s.HistoryEntries(nil)) {
+		t.Fatalf("Round-trip failure: %+v", entries)
+	}
+
+	between := s2.HistoryBetween(time.Unix(1700000000, 0), time.Unix(1700000100, 0))
+	if len(between) != 1 || between[0].Line != "make test" {
+		t.Errorf("Unexpected entries between times: %+v", between)
+	}
+	with := s2.HistoryWithMeta("ticket", "ABC-1")
+	if len(with) != 1 || with[0].Line != "make test" {
+		t.Errorf("Unexpected entries with metadata: %+v", with)
+	}
+}
+
+func TestHistoryMultiLine(t *testing.T) {
+	var s State
+	s.AppendHistory("select 1\nfrom t;")
+	s.AppendHistory("#1\n#2")
+	s.AppendHistory("ls")
+
+	var out bytes.Buffer
+	if _, err := s.WriteHistory(&out); err != nil {
+		t.Fatal("Unexpected error writing history", err)
+	}
+	var s2 State
+	num, err := s2.ReadHistory(&out)
+	if err != nil || num != 3 {
+		t.Fatal("Unexpected result reading history", num, err)
+	}
+	if !reflect.DeepEqual(s2.HistoryEntries(nil), s.HistoryEntries(nil)) {
+		t.Fatalf("Round-trip failure: %+v", s2.HistoryEntries(nil))
+	}
+
+	_, err = s2.ReadHistory(strings.NewReader("#liner-history v2\n#0 lines=3\none\ntwo\n"))
+	if err == nil {
+		t.Error("Expected an error reading a truncated entry")
+	}
+}
+
+func TestHistoryPlain(t *testing.T) {
+	var s State
+	num, err := s.ReadHistory(strings.NewReader("ls\n#42\nmake\n#1\n"))
+	if err != nil || num != 4 {
+		t.Fatal("Unexpected result reading history", num, err)
+	}
+	expected := []HistoryEntry{{Line: "ls"}, {Line: "#42"}, {Line: "make"}, {Line: "#1"}}
+	if entries := s.HistoryEntries(nil); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, entries)
+	}
+
+	var s2 State
+	s2.AppendHistory("ls")
+	s2.AppendHistory("make")
+	var out bytes.Buffer
+	if _, err := s2.WriteHistory(&out); err != nil || out.String() != "ls\nmake\n" {
+		t.Errorf("Expected a plain history file, got %q (%v)", out.String(), err)
+	}
+}
+
 func TestColumns(t *testing.T) {
 	list := []string{"foo", "food", "This entry is quite a bit longer than the typical entry"}
 
@@ -144,3 +239,72 @@ func ExampleState_WriteHistory() {
 	// History entry 0 : foo
 	// History entry 1 : bar
 }
+
+func TestOptionsRedirected(t *testing.T) {
+	var out bytes.Buffer
+	s := NewLinerWithOptions(Options{
+		Input:  strings.NewReader("hello\nworld\n"),
+		Output: &out,
+	})
+	defer s.Close()
+
+	line, err := s.Prompt("> ")
+	if err != nil {
+		t.Fatal("Unexpected error from Prompt", err)
+	}
+	if line != "hello" {
+		t.Fatalf("Expected \"hello\", got %q", line)
+	}
+	line, err = s.Prompt("> ")
+	if err != nil {
+		t.Fatal("Unexpected error from Prompt", err)
+	}
+	if line != "world" {
+		t.Fatalf("Expected \"world\", got %q", line)
+	}
+	if out.String() != "> > " {
+		t.Fatalf("Expected prompts on the configured output, got %q", out.String())
+	}
+	if s.LineEditing() {
+		t.Error("Expected no line editing of redirected input")
+	}
+}
+
+func TestIsCompleteRedirected(t *testing.T) {
+	s := NewLinerWithOptions(Options{
+		Input:  strings.NewReader("select 1\nfrom t;\n"),
+		Output: &bytes.Buffer{},
+	})
+	defer s.Close()
+	s.SetIsComplete(func(text string) bool {
+		return strings.HasSuffix(text, ";")
+	})
+
+	line, err := s.Prompt("> ")
+	if err != nil {
+		t.Fatal("Unexpected error from Prompt", err)
+	}
+	if line != "select 1\nfrom t;" {
+		t.Fatalf("Expected both lines, got %q", line)
+	}
+}
+
+func TestStyledPromptRedirected(t *testing.T) {
+	var out bytes.Buffer
+	s := NewLinerWithOptions(Options{
+		Input:  strings.NewReader("select 1\nfrom t;\n"),
+		Output: &out,
+	})
+	defer s.Close()
+	s.SetIsComplete(func(text string) bool {
+		return strings.HasSuffix(text, ";")
+	})
+	s.SetContinuationPrompt("\x1b[2m..\x1b[0m ")
+
+	if _, err := s.Prompt("\x1b[32m>\x1b[0m "); err != nil {
+		t.Fatal("Unexpected error from Prompt", err)
+	}
+	if out.String() != "> .. " {
+		t.Fatalf("Expected prompts without styles, got %q", out.String())
+	}
+}
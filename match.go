@@ -0,0 +1,177 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The matched runes of candidates are underlined in lists. Underlining is
+// turned off by its own code rather than a reset, so that it can be used
+// within the selected item of the menu.
+const (
+	matchStyle    = "4"
+	matchStyleOff = "24"
+)
+
+// Scores for the parts of a match
+const (
+	matchRune      = 1 // each matched rune
+	matchAdjacent  = 4 // a rune that follows the previous matched rune
+	matchWordStart = 3 // a rune that starts a word
+)
+
+// matchCandidates returns the candidates in list that match word, as
+// SetCompletionMatching selects, with the best matches first, and records
+// the runes that matched in s.matched.
+func (s *State) matchCandidates(word []rune, list []Candidate) []Candidate {
+	match := fuzzyMatch
+	if s.matching == MatchSubstring {
+		match = substringMatch
+	}
+	var kept []Candidate
+	var scores []int
+	s.matched = make(map[string][]int)
+	for _, c := range list {
+		score, positions, ok := match(word, []rune(c.Text))
+		if !ok {
+			continue
+		}
+		kept = append(kept, c)
+		scores = append(scores, score)
+		s.matched[c.Text] = positions
+	}
+	sort.Stable(byScore{kept, scores})
+	return kept
+}
+
+type byScore struct {
+	list   []Candidate
+	scores []int
+}
+
+func (b byScore) Len() int           { return len(b.list) }
+func (b byScore) Less(i, j int) bool { return b.scores[i] > b.scores[j] }
+func (b byScore) Swap(i, j int) {
+	b.list[i], b.list[j] = b.list[j], b.list[i]
+	b.scores[i], b.scores[j] = b.scores[j], b.scores[i]
+}
+
+// startsWord reports whether the rune at i in text starts a word, after a
+// separator such as '/' or '_', or at a change from lower to upper case
+func startsWord(text []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev := text[i-1]
+	if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+		return true
+	}
+	return unicode.IsLower(prev) && unicode.IsUpper(text[i])
+}
+
+// fuzzyMatch reports whether the runes of query appear in text in order,
+// ignoring case, with a score that is higher for closer matches and the
+// positions in text of the matched runes.
+func fuzzyMatch(query, text []rune) (int, []int, bool) {
+	var positions []int
+	for i := 0; i < len(text) && len(positions) < len(query); i++ {
+		if unicode.ToLower(text[i]) == unicode.ToLower(query[len(positions)]) {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) < len(query) {
+		return 0, nil, false
+	}
+	return matchScore(text, positions), positions, true
+}
+
+// matchScore returns the score of a match of the runes at positions in text
+func matchScore(text []rune, positions []int) int {
+	if len(positions) == 0 {
+		return 0
+	}
+	score := 0
+	for n, i := range positions {
+		score += matchRune
+		if n > 0 && positions[n-1] == i-1 {
+			score += matchAdjacent
+		}
+		if startsWord(text, i) {
+			score += matchWordStart
+		}
+	}
+	// Prefer matches that start early, and that are not spread out
+	gaps := positions[len(positions)-1] - positions[0] + 1 - len(positions)
+	return score - positions[0] - gaps
+}
+
+// substringMatch reports whether query appears in text, ignoring case,
+// with a score from matchScore and the positions in text of the matched
+// runes.
+func substringMatch(query, text []rune) (int, []int, bool) {
+	lowerText := []rune(strings.ToLower(string(text)))
+	lowerQuery := []rune(strings.ToLower(string(query)))
+	if len(lowerText) != len(text) || len(lowerQuery) != len(query) {
+		// Lower casing changed the number of runes
+		lowerText, lowerQuery = text, query
+	}
+	for i := 0; i+len(query) <= len(text); i++ {
+		if string(lowerText[i:i+len(query)]) != string(lowerQuery) {
+			continue
+		}
+		positions := make([]int, len(query))
+		for n := range positions {
+			positions[n] = i + n
+		}
+		return matchScore(text, positions), positions, true
+	}
+	return 0, nil, false
+}
+
+// highlightMatches returns text, which is the start of the Text of c, with
+// the runes that matched underlined.
+func (s *State) highlightMatches(c Candidate, text []rune) string {
+	positions := s.matched[c.Text]
+	if len(positions) == 0 || c.Display != "" || s.noStyles {
+		return string(text)
+	}
+	var b strings.Builder
+	on := false
+	for i, r := range text {
+		matched := len(positions) > 0 && positions[0] == i
+		if matched {
+			positions = positions[1:]
+		}
+		if matched != on {
+			if matched {
+				b.WriteString("\x1b[" + matchStyle + "m")
+			} else {
+				b.WriteString("\x1b[" + matchStyleOff + "m")
+			}
+			on = matched
+		}
+		b.WriteRune(r)
+	}
+	if on {
+		b.WriteString("\x1b[" + matchStyleOff + "m")
+	}
+	return b.String()
+}
+
+// keepWord returns prefix, the longest common prefix of the candidates that
+// matched word, unless it would not extend word, when word is kept as it
+// is instead. Matched candidates need not begin with the word.
+func (s *State) keepWord(prefix string, word []rune) string {
+	if s.matching == MatchNone {
+		return prefix
+	}
+	p := []rune(prefix)
+	if len(p) < len(word) || !strings.EqualFold(string(p[:len(word)]), string(word)) {
+		return string(word)
+	}
+	return prefix
+}
@@ -0,0 +1,81 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchCandidates(t *testing.T) {
+	list := plainCandidates([]string{
+		"format_bytes", "fmt_buffer", "file_backup", "forbid", "FooBar", "refbox",
+	})
+	tests := []struct {
+		matching Matching
+		word     string
+		texts    []string
+	}{
+		{MatchFuzzy, "fb", []string{"FooBar", "fmt_buffer", "file_backup", "refbox", "forbid", "format_bytes"}},
+		{MatchFuzzy, "FRB", []string{"forbid", "format_bytes"}},
+		{MatchFuzzy, "", []string{"format_bytes", "fmt_buffer", "file_backup", "forbid", "FooBar", "refbox"}},
+		{MatchFuzzy, "xyz", nil},
+		{MatchSubstring, "b", []string{"FooBar", "fmt_buffer", "file_backup", "forbid", "refbox", "format_bytes"}},
+		{MatchSubstring, "BU", []string{"fmt_buffer"}},
+	}
+	for _, test := range tests {
+		var s State
+		s.SetCompletionMatching(test.matching)
+		matched := s.matchCandidates([]rune(test.word), list)
+		var texts []string
+		for _, c := range matched {
+			texts = append(texts, c.Text)
+		}
+		if !reflect.DeepEqual(texts, test.texts) {
+			t.Errorf("Matching %q: expected %q, got %q", test.word, test.texts, texts)
+		}
+	}
+}
+
+func TestHighlightMatches(t *testing.T) {
+	var s State
+	s.SetCompletionMatching(MatchFuzzy)
+	c := Candidate{Text: "fmt_buffer"}
+	s.matchCandidates([]rune("mbu"), []Candidate{c})
+	if got, want := s.highlightMatches(c, []rune(c.Text)), "f\x1b[4mm\x1b[24mt_\x1b[4mbu\x1b[24mffer"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if got := s.highlightMatches(Candidate{Text: "fmt_buffer", Display: "fmt"}, []rune("fmt")); got != "fmt" {
+		t.Errorf("Expected display text to be left alone, got %q", got)
+	}
+}
+
+func TestCompletionMatching(t *testing.T) {
+	names := []string{"format_bytes", "fmt_buffer", "FooBar"}
+	tests := []struct {
+		style       TabStyle
+		input, line string
+		shown       string
+	}{
+		{TabPrints, "fb\t\t\r", "fb", "\x1b[4mF\x1b[24moo\x1b[4mB\x1b[24mar"},
+		{TabPrints, "mbu\t\r", "fmt_buffer", ""},
+		{TabCircular, "fb\t\r", "FooBar", ""},
+		{TabMenu, "fb\t\t\r\r", "fmt_buffer", "\x1b[7m \x1b[4mf\x1b[24mmt_\x1b[4mb\x1b[24muffer   \x1b[0m"},
+	}
+	for _, test := range tests {
+		s := newTestSession(t, 80)
+		s.SetTabCompletionStyle(test.style)
+		s.SetCompletionMatching(MatchFuzzy)
+		s.SetCompleter(func(line string) []string {
+			return names
+		})
+		if line := promptWith(t, s, test.input); line != test.line {
+			t.Errorf("Input %q: expected %q, got %q", test.input, test.line, line)
+		}
+		if shown := s.output.String(); !strings.Contains(shown, test.shown) {
+			t.Errorf("Input %q: expected %q to be shown, got %q", test.input, test.shown, shown)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	menuStyle    = "7" // the style of the selected item (reverse video)
+	menuPageRows = 8   // the most rows of items that the menu shows at once
+)
+
+// menuRow is a row of the completion menu: either the heading of a group,
+// or n items from first on.
+type menuRow struct {
+	heading  string
+	first, n int
+}
+
+// menuLayout returns the rows of the completion menu for items, and the
+// widths from candidateWidths.
+func (s *State) menuLayout(items []Candidate) (rows []menuRow, width, displayWidth int) {
+	width, displayWidth = s.candidateWidths(items)
+	numColumns := (s.columns - 1) / width
+	if numColumns < 1 {
+		numColumns = 1
+	}
+	first := 0
+	for _, group := range candidateGroups(items) {
+		if group[0].Group != "" {
+			rows = append(rows, menuRow{heading: group[0].Group})
+		}
+		for i := 0; i < len(group); i += numColumns {
+			n := numColumns
+			if i+n > len(group) {
+				n = len(group) - i
+			}
+			rows = append(rows, menuRow{first: first + i, n: n})
+		}
+		first += len(group)
+	}
+	return rows, width, displayWidth
+}
+
+// menuRowOf returns the index of the row of the menu that holds item sel
+func menuRowOf(rows []menuRow, sel int) int {
+	for i, row := range rows {
+		if row.first <= sel && sel < row.first+row.n {
+			return i
+		}
+	}
+	return 0
+}
+
+// menuRows returns the rows of the completion menu for items that show the
+// page which holds the selected item, sel.
+func (s *State) menuRows(items []Candidate, sel int) [][]rune {
+	layout, width, displayWidth := s.menuLayout(items)
+	first := menuRowOf(layout, sel) / menuPageRows * menuPageRows
+	last := first + menuPageRows
+	if last > len(layout) {
+		last = len(layout)
+	}
+
+	var rows [][]rune
+	for _, row := range layout[first:last] {
+		if row.n == 0 {
+			rows = append(rows, []rune(s.candidateHeading(row.heading)))
+			continue
+		}
+		var text string
+		for i := row.first; i < row.first+row.n; i++ {
+			text += s.candidateCell(items[i], width, displayWidth, i == sel)
+		}
+		rows = append(rows, []rune(text))
+	}
+	if len(layout) > menuPageRows {
+		page := fmt.Sprintf("rows %d-%d of %d", first+1, last, len(layout))
+		if !s.noStyles {
+			page = fmt.Sprintf("\x1b[%sm%s\x1b[0m", suggestStyle, page)
+		}
+		rows = append(rows, []rune(page))
+	}
+	return rows
+}
+
+// menuMove returns the item selected after next is pressed in the
+// completion menu for items, or false if next does not move the selection.
+func (s *State) menuMove(items []Candidate, sel int, next interface{}) (int, bool) {
+	rows, _, _ := s.menuLayout(items)
+	row := menuRowOf(rows, sel)
+	column := sel - rows[row].first
+
+	// to returns the item in the same column of the first row of items at
+	// or after (if step is 1) or before (if step is -1) row i
+	to := func(i, step int) int {
+		for rows[i].n == 0 {
+			i = (i + step + len(rows)) % len(rows)
+		}
+		if column >= rows[i].n {
+			return rows[i].first + rows[i].n - 1
+		}
+		return rows[i].first + column
+	}
+
+	switch next {
+	case rune(tab), right:
+		return (sel + 1) % len(items), true
+	case shiftTab, left:
+		return (sel - 1 + len(items)) % len(items), true
+	case down, rune(ctrlN):
+		return to((row+1)%len(rows), 1), true
+	case up, rune(ctrlP):
+		return to((row-1+len(rows))%len(rows), -1), true
+	case pageDown:
+		if row+menuPageRows >= len(rows) {
+			return len(items) - 1, true
+		}
+		return to(row+menuPageRows, 1), true
+	case pageUp:
+		if row-menuPageRows < 0 {
+			return 0, true
+		}
+		return to(row-menuPageRows, 1), true
+	}
+	return sel, false
+}
+
+// menuComplete lets the user pick one of the completions in list from a
+// menu drawn below the prompt. The menu is erased when a completion is
+// accepted, by Enter or by any key that does not move the selection, or
+// cancelled, by Esc or Ctrl-G.
+func (s *State) menuComplete(p []rune, line []rune, pos int, head string, list []Candidate, tail string) ([]rune, int, interface{}, error) {
+	defer func() { s.menu = nil }()
+	hl := utf8.RuneCountInString(head)
+	sel := 0
+	for {
+		pick := list[sel].chosen()
+		newLine, newPos := []rune(head+pick+tail), hl+utf8.RuneCountInString(pick)
+		s.menu = s.menuRows(list, sel)
+		if err := s.refresh(p, newLine, newPos); err != nil {
+			return line, pos, rune(esc), err
+		}
+
+		next, err := s.readNext()
+		if err != nil {
+			return line, pos, rune(esc), err
+		}
+		if moved, ok := s.menuMove(list, sel, next); ok {
+			sel = moved
+			continue
+		}
+
+		s.menu = nil
+		switch next {
+		case rune(esc), rune(ctrlG):
+			return line, pos, rune(esc), s.refresh(p, line, pos)
+		case rune(cr), rune(lf):
+			s.restartPrompt()
+			return newLine, newPos, rune(esc), s.refresh(p, newLine, newPos)
+		}
+		return newLine, newPos, next, s.refresh(p, newLine, newPos)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTabMenu(t *testing.T) {
+	var items []string
+	for i := 0; i < 80; i++ {
+		items = append(items, fmt.Sprintf("a%02d", i))
+	}
+	// 40 columns fit 7 items to a row, so there are 12 rows
+	tests := []struct {
+		input, line string
+	}{
+		{"\t\r\r", "a00"},
+		{"\t\t\t\r\r", "a02"},
+		{"\t\x1b[Z\r\r", "a79"},
+		{"\t\x1b[C\x1b[D\x1b[D\r\r", "a79"},
+		{"\t\x1b[B\r\r", "a07"},
+		{"\t\x1b[A\r\r", "a77"},
+		{"\t\x1b[6~\r\r", "a56"},
+		{"\t\x1b[6~\x1b[5~\r\r", "a00"},
+		{"\t\t\x07\r", ""},
+		{"\t\tb\r", "a01b"},
+	}
+	for _, test := range tests {
+		s := newTestSession(t, 40)
+		s.SetTabCompletionStyle(TabMenu)
+		s.SetCompleter(func(line string) []string {
+			return items
+		})
+		if line := promptWith(t, s, test.input); line != test.line {
+			t.Errorf("Input %q: expected %q, got %q", test.input, test.line, line)
+		}
+		shown := s.output.String()
+		if !strings.Contains(shown, "\x1b[7m a00 \x1b[0m") || !strings.Contains(shown, "rows 1-8 of 12") {
+			t.Errorf("Input %q: expected the menu to be drawn, got %q", test.input, shown)
+		}
+		if i := strings.LastIndex(shown, " of 12"); i < 0 || !strings.Contains(shown[i:], "\x1b[1B\r\x1b[0K") {
+			t.Errorf("Input %q: expected the menu to be erased, got %q", test.input, shown)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+// lineStart returns the position of the start of the line of text that pos
+// is on, where text may contain several lines
+func lineStart(text []rune, pos int) int {
+	for pos > 0 && text[pos-1] != '\n' {
+		pos--
+	}
+	return pos
+}
+
+// lineEnd returns the position of the end of the line of text that pos is on
+func lineEnd(text []rune, pos int) int {
+	for pos < len(text) && text[pos] != '\n' {
+		pos++
+	}
+	return pos
+}
+
+// atColumn returns the position in the line of text that starts at start
+// that is closest to being column glyphs along it
+func atColumn(text []rune, start int, column int) int {
+	end := lineEnd(text, start)
+	pos := start
+	for pos < end {
+		next := pos + len(getPrefixGlyphs(text[pos:end], 1))
+		if countGlyphs(text[start:next]) > column {
+			break
+		}
+		pos = next
+	}
+	return pos
+}
+
+// lineUp returns the position in the previous line of text that is in the
+// same column as pos, or false if pos is on the first line
+func lineUp(text []rune, pos int) (int, bool) {
+	start := lineStart(text, pos)
+	if start == 0 {
+		return pos, false
+	}
+	return atColumn(text, lineStart(text, start-1), countGlyphs(text[start:pos])), true
+}
+
+// lineDown returns the position in the next line of text that is in the
+// same column as pos, or false if pos is on the last line
+func lineDown(text []rune, pos int) (int, bool) {
+	end := lineEnd(text, pos)
+	if end == len(text) {
+		return pos, false
+	}
+	return atColumn(text, end+1, countGlyphs(text[lineStart(text, pos):pos])), true
+}
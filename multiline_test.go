@@ -0,0 +1,32 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsComplete(t *testing.T) {
+	s := newTestSession(t, 80)
+	s.SetIsComplete(func(text string) bool {
+		return strings.Contains(text, ";")
+	})
+	s.AppendHistory("old;")
+
+	tests := []struct {
+		input, line string
+	}{
+		{"select 1\rfrom t;\r", "select 1\nfrom t;"},
+		{"ab\rcd\x10X\x0e;\r", "abX\ncd;"},
+		{"ab\rcd\x01X;\r", "ab\nX;cd"},
+		{"abc\rd\x1b[AY\x05;\r", "aYbc;\nd"},
+		{"\x10\r", "old;"},
+	}
+	for _, test := range tests {
+		if line := promptWith(t, s, test.input); line != test.line {
+			t.Errorf("Expected %q after %q, got %q", test.line, test.input, line)
+		}
+	}
+}
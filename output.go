@@ -10,36 +10,27 @@ import (
 )
 
 func (s *State) cursorPos(x int) {
-	if s.useCHA {
-		// 'G' is "Cursor Character Absolute (CHA)"
-		fmt.Printf("\x1b[%dG", x+1)
-	} else {
-		// 'C' is "Cursor Forward (CUF)"
-		fmt.Print("\r")
-		if x > 0 {
-			fmt.Printf("\x1b[%dC", x)
-		}
-	}
+	s.ansiCursorPos(x)
 }
 
 func (s *State) eraseLine() {
-	fmt.Print("\x1b[0K")
+	s.ansiEraseLine()
 }
 
 func (s *State) eraseScreen() {
-	fmt.Print("\x1b[H\x1b[2J")
+	s.ansiEraseScreen()
 }
 
 func (s *State) moveUp(lines int) {
-	fmt.Printf("\x1b[%dA", lines)
+	s.ansiMoveUp(lines)
 }
 
 func (s *State) moveDown(lines int) {
-	fmt.Printf("\x1b[%dB", lines)
+	s.ansiMoveDown(lines)
 }
 
 func (s *State) emitNewLine() {
-	fmt.Print("\n")
+	fmt.Fprint(s.w, "\n")
 }
 
 type winSize struct {
@@ -5,7 +5,11 @@ import (
 )
 
 func (s *State) getColumns() bool {
-	ws, err := unix.IoctlGetWinsize(unix.Stdout, unix.TIOCGWINSZ)
+	if s.columnsFunc != nil {
+		s.columns = s.columnsFunc()
+		return s.columns > 0
+	}
+	ws, err := unix.IoctlGetWinsize(s.outFd, unix.TIOCGWINSZ)
 	if err != nil {
 		return false
 	}
@@ -9,8 +9,12 @@ import (
 )
 
 func (s *State) getColumns() bool {
+	if s.columnsFunc != nil {
+		s.columns = s.columnsFunc()
+		return s.columns > 0
+	}
 	var ws winSize
-	ok, _, _ := syscall.Syscall(syscall.SYS_IOCTL, uintptr(syscall.Stdout),
+	ok, _, _ := syscall.Syscall(syscall.SYS_IOCTL, uintptr(s.outFd),
 		syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
 	if int(ok) < 0 {
 		return false
@@ -1,6 +1,7 @@
 package liner
 
 import (
+	"fmt"
 	"unsafe"
 )
 
@@ -20,6 +21,10 @@ type consoleScreenBufferInfo struct {
 }
 
 func (s *State) cursorPos(x int) {
+	if s.ansiOutput {
+		s.ansiCursorPos(x)
+		return
+	}
 	var sbi consoleScreenBufferInfo
 	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
 	procSetConsoleCursorPosition.Call(uintptr(s.hOut),
@@ -27,6 +32,10 @@ func (s *State) cursorPos(x int) {
 }
 
 func (s *State) eraseLine() {
+	if s.ansiOutput {
+		s.ansiEraseLine()
+		return
+	}
 	var sbi consoleScreenBufferInfo
 	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
 	var numWritten uint32
@@ -37,6 +46,10 @@ func (s *State) eraseLine() {
 }
 
 func (s *State) eraseScreen() {
+	if s.ansiOutput {
+		s.ansiEraseScreen()
+		return
+	}
 	var sbi consoleScreenBufferInfo
 	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
 	var numWritten uint32
@@ -48,6 +61,10 @@ func (s *State) eraseScreen() {
 }
 
 func (s *State) moveUp(lines int) {
+	if s.ansiOutput {
+		s.ansiMoveUp(lines)
+		return
+	}
 	var sbi consoleScreenBufferInfo
 	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
 	procSetConsoleCursorPosition.Call(uintptr(s.hOut),
@@ -55,6 +72,10 @@ func (s *State) moveUp(lines int) {
 }
 
 func (s *State) moveDown(lines int) {
+	if s.ansiOutput {
+		s.ansiMoveDown(lines)
+		return
+	}
 	var sbi consoleScreenBufferInfo
 	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
 	procSetConsoleCursorPosition.Call(uintptr(s.hOut),
@@ -62,10 +83,17 @@ func (s *State) moveDown(lines int) {
 }
 
 func (s *State) emitNewLine() {
-	// windows doesn't need to omit a new line
+	// windows doesn't need to omit a new line, but other terminals do
+	if s.ansiOutput {
+		fmt.Fprint(s.w, "\n")
+	}
 }
 
 func (s *State) getColumns() {
+	if s.columnsFunc != nil {
+		s.columns = s.columnsFunc()
+		return
+	}
 	var sbi consoleScreenBufferInfo
 	procGetConsoleScreenBufferInfo.Call(uintptr(s.hOut), uintptr(unsafe.Pointer(&sbi)))
 	s.columns = int(sbi.dwSize.x)
@@ -0,0 +1,189 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStyledPrompt(t *testing.T) {
+	s := newTestSession(t, 80)
+
+	s.send("\r")
+	line, err := s.PromptWithSuggestion("\x1b[32m>\x1b[0m ", "abc", -1)
+	if err != nil || line != "abc" {
+		t.Fatal("Unexpected result from Prompt", line, err)
+	}
+	// The cursor is placed after the prompt's 2 glyphs and the line
+	if out := s.output.String(); !strings.Contains(out, "\x1b[32m>\x1b[0m abc\x1b[0K\r\x1b[5C") {
+		t.Errorf("Cursor was misplaced in %q", out)
+	}
+
+	if _, err := s.Prompt("\a> "); err != ErrInvalidPrompt {
+		t.Error("Expected ErrInvalidPrompt, got", err)
+	}
+	if _, err := s.Prompt("\x1b[2J> "); err != ErrInvalidPrompt {
+		t.Error("Expected ErrInvalidPrompt, got", err)
+	}
+}
+
+func TestMultiRowPrompt(t *testing.T) {
+	s := newTestSession(t, 80)
+
+	// In single-line mode, the rows above the line are drawn once, and
+	// again (after erasing them) when a message is printed
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		line, err := s.Prompt("[status]\n> ")
+		done <- result{line, err}
+	}()
+	s.send("abc")
+	if !s.waitFor("abc") {
+		t.Fatalf("Expected typed text to be shown, got %q", s.output.String())
+	}
+	s.Printf("message")
+	s.send("\r")
+	r := <-done
+	if r.err != nil || r.line != "abc" {
+		t.Fatal("Unexpected result from Prompt", r.line, r.err)
+	}
+	expected := "[status]\r\n> \x1b[?2004habc\r\x1b[0K\x1b[1A\x1b[0Kmessage\r\n[status]\r\n\r> abc"
+	if out := s.output.String(); !strings.Contains(out, expected) {
+		t.Errorf("Expected %q in %q", expected, out)
+	}
+
+	// In multi-line mode, the continuation prompt follows each newline
+	s.SetMultiLineMode(true)
+	s.SetPasteNewlines(true)
+	s.SetContinuationPrompt("... ")
+	s.send("\x1b[200~one\ntwo\x1b[201~\r")
+	line, err := s.Prompt("[status]\n> ")
+	if err != nil || line != "one\ntwo" {
+		t.Fatal("Unexpected result from Prompt", line, err)
+	}
+	if out := s.output.String(); !strings.Contains(out, "[status]\r\n> one\r\n... two") {
+		t.Errorf("Continuation prompt was not shown in %q", out)
+	}
+
+	s.SetContinuationPrompt("\n")
+	if _, err := s.Prompt("> "); err != ErrInvalidPrompt {
+		t.Error("Expected ErrInvalidPrompt, got", err)
+	}
+}
+
+func TestRightPrompt(t *testing.T) {
+	tests := []struct {
+		multiLine   bool
+		text, input string
+		shown       bool
+	}{
+		{false, "abc", "", true},
+		{false, strings.Repeat("x", 31), "", false},
+		{false, "", "", true},
+		{false, "", "xyz", true},
+		{true, "abc", "", true},
+		{true, strings.Repeat("x", 50), "", false},
+		{true, "", "xyz", true},
+	}
+	for _, test := range tests {
+		s := newTestSession(t, 40)
+		s.SetMultiLineMode(test.multiLine)
+		s.SetRightPrompt(func() string {
+			return "\x1b[33m[main]\x1b[0m"
+		})
+
+		s.send(test.input + "\r")
+		line, err := s.PromptWithSuggestion("> ", test.text, -1)
+		if err != nil || line != test.text+test.input {
+			t.Fatal("Unexpected result from Prompt", line, err)
+		}
+		// [main] is 6 columns wide, and ends one column from the edge
+		out := s.output.String()
+		shown := strings.Contains(out, "\x1b[33C\x1b[33m[main]\x1b[0m")
+		if shown != test.shown {
+			t.Errorf("Expected right prompt shown to be %t with %q and %q typed in multi-line mode %t, got %q",
+				test.shown, test.text, test.input, test.multiLine, out)
+		}
+		// Typing must not write over the right prompt
+		if test.input != "" && strings.LastIndex(out, "z") > strings.LastIndex(out, "[main]") {
+			t.Errorf("Expected right prompt to be redrawn after typing in multi-line mode %t, got %q",
+				test.multiLine, out)
+		}
+	}
+}
+
+func TestTransientPrompt(t *testing.T) {
+	for _, multiLine := range []bool{false, true} {
+		s := newTestSession(t, 40)
+		s.SetMultiLineMode(multiLine)
+		s.SetTransientPrompt(func(line string) string {
+			return "$ " + line
+		})
+
+		s.send("ls\r")
+		line, err := s.Prompt("[status]\n> ")
+		if err != nil || line != "ls" {
+			t.Fatal("Unexpected result from Prompt", line, err)
+		}
+		// Both rows of the prompt are erased before the transient prompt
+		out := s.output.String()
+		if !strings.HasSuffix(out, "\x1b[1A\x1b[0K$ ls\r\n\x1b[?2004l") &&
+			!strings.HasSuffix(out, "\x1b[1A\r\x1b[0K$ ls\r\n\x1b[?2004l") {
+			t.Errorf("Expected transient prompt in multi-line mode %t, got %q", multiLine, out)
+		}
+	}
+}
+
+func TestStatusLine(t *testing.T) {
+	for _, multiLine := range []bool{false, true} {
+		s := newTestSession(t, 40)
+		s.SetMultiLineMode(multiLine)
+		s.SetStatusLine(func(line string, pos int) string {
+			return fmt.Sprintf("len=%d pos=%d\nignored", len(line), pos)
+		})
+
+		s.send("\r")
+		line, err := s.PromptWithSuggestion("> ", "abc", 1)
+		if err != nil || line != "abc" {
+			t.Fatal("Unexpected result from Prompt", line, err)
+		}
+		out := s.output.String()
+		drawn := "\r\n\r\x1b[0Klen=3 pos=1\x1b[1A\r\x1b[3C"
+		cleared := "\x1b[1B\r\x1b[0K\x1b[1A\r\x1b[3C"
+		if i := strings.Index(out, drawn); i < 0 || !strings.Contains(out[i:], cleared) {
+			t.Errorf("Expected status line to be drawn and cleared in multi-line mode %t, got %q", multiLine, out)
+		}
+	}
+
+	// A status line that is too wide is cut to fit on one row
+	s := newTestSession(t, 20)
+	s.SetStatusLine(func(line string, pos int) string {
+		return strings.Repeat("状態", 10)
+	})
+	if n := countGlyphs(s.statusText(nil, 0)); n != 18 {
+		t.Errorf("Expected a status line of 9 wide glyphs, got %d columns", n)
+	}
+
+	// The status line is shown before the first key of an empty line
+	s = newTestSession(t, 40)
+	s.SetStatusLine(func(line string, pos int) string {
+		return "hint"
+	})
+	done := make(chan struct{})
+	go func() {
+		s.Prompt("> ")
+		close(done)
+	}()
+	if !s.waitFor("hint") {
+		t.Errorf("Expected status line before the first key, got %q", s.output.String())
+	}
+	s.send("\r")
+	<-done
+}
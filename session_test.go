@@ -0,0 +1,172 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type pipeSession struct {
+	io.Reader
+	io.Writer
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// testSession is a State created by NewSessionLiner, whose keystrokes a
+// test types and whose output it reads
+type testSession struct {
+	*State
+	input  *io.PipeWriter
+	output *syncBuffer
+}
+
+// newTestSession returns a session of a terminal columns wide, which is
+// closed when the test ends.
+func newTestSession(t *testing.T, columns int) *testSession {
+	inr, inw := io.Pipe()
+	s := &testSession{input: inw, output: &syncBuffer{}}
+	s.State = NewSessionLiner(pipeSession{inr, s.output}, columns)
+	t.Cleanup(func() {
+		s.Close()
+		inw.Close()
+	})
+	return s
+}
+
+// send types input, without waiting for it to be read
+func (s *testSession) send(input string) {
+	go io.WriteString(s.input, input)
+}
+
+// waitFor waits for up to a second for text to be shown, and reports
+// whether it was
+func (s *testSession) waitFor(text string) bool {
+	for i := 0; i < 100; i++ {
+		if strings.Contains(s.output.String(), text) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+// promptWith types input at a prompt of s, and returns the line that the
+// prompt returns.
+func promptWith(t *testing.T, s *testSession, input string) string {
+	t.Helper()
+	s.send(input)
+	line, err := s.Prompt("> ")
+	if err != nil {
+		t.Fatalf("Unexpected error from Prompt after %q: %v", input, err)
+	}
+	return line
+}
+
+func TestSession(t *testing.T) {
+	s := newTestSession(t, 80)
+	if !s.LineEditing() {
+		t.Error("Expected line editing of a session")
+	}
+	s.AppendHistory("show users")
+	s.SetCompleter(func(line string) []string {
+		if strings.HasPrefix("show", line) {
+			return []string{"show"}
+		}
+		return nil
+	})
+
+	if line := promptWith(t, s, "\x1b[A\r"); line != "show users" {
+		t.Fatalf("Expected history entry, got %q", line)
+	}
+
+	s.Resize(40)
+	s.Resize(60)
+	if line := promptWith(t, s, "sh\t users\r"); line != "show users" {
+		t.Fatalf("Expected completed line, got %q", line)
+	}
+	if s.columns != 60 {
+		t.Fatalf("Expected 60 columns after Resize, got %d", s.columns)
+	}
+
+	out := s.output.String()
+	if strings.Contains(strings.Replace(out, "\r\n", "", -1), "\n") {
+		t.Fatalf("Expected CRLF line endings, got %q", out)
+	}
+}
+
+func TestPromptContext(t *testing.T) {
+	s := newTestSession(t, 80)
+
+	s.send("ab")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := s.PromptContext(ctx, "> ")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// Keystrokes typed after cancellation belong to the next prompt
+	if line := promptWith(t, s, "cd\r"); line != "cd" {
+		t.Fatalf("Expected \"cd\", got %q", line)
+	}
+}
+
+func TestPrintf(t *testing.T) {
+	s := newTestSession(t, 80)
+
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result)
+	go func() {
+		line, err := s.Prompt("> ")
+		done <- result{line, err}
+	}()
+
+	s.send("abc")
+	if !s.waitFor("abc") {
+		t.Fatalf("Expected typed text to be shown, got %q", s.output.String())
+	}
+	s.Printf("message %d", 1)
+	s.send("\r")
+	r := <-done
+	if r.err != nil {
+		t.Fatal("Unexpected error from Prompt", r.err)
+	}
+	if r.line != "abc" {
+		t.Fatalf("Expected \"abc\", got %q", r.line)
+	}
+	shown := s.output.String()
+	i := strings.Index(shown, "message 1\r\n")
+	if i < 0 {
+		t.Fatalf("Expected message on its own line, got %q", shown)
+	}
+	if !strings.Contains(shown[i:], "> abc") {
+		t.Fatalf("Expected prompt to be redrawn after message, got %q", shown)
+	}
+}
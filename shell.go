@@ -0,0 +1,211 @@
+package liner
+
+import (
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// On Windows, backslash separates the elements of paths rather than
+// escaping the next character, and words are quoted instead of escaped.
+const backslashEscapes = runtime.GOOS != "windows"
+
+// shellSpecial holds the characters that are escaped in words
+const shellSpecial = " \t\\'\"`$&|;<>()[]{}*?!#"
+
+// ArgsCompleter takes the words of the line, split and unquoted as a POSIX
+// shell would, the index in args of the word under the cursor, and the
+// part of that word before the cursor (also unquoted), and returns the
+// completion candidates for the word. If the cursor is not on a word, an
+// empty word is inserted into args at the cursor.
+//
+// The Text of each candidate is unquoted; it is quoted as the word under
+// the cursor was when the candidate is inserted.
+type ArgsCompleter func(args []string, index int, prefix string) []Candidate
+
+// ShellWords returns a CandidateCompleter that splits the line into words
+// for f, and quotes or escapes the candidates that f returns. A quote that
+// the word under the cursor opened is closed after a candidate, unless its
+// Suffix ends with "/", for a directory.
+func ShellWords(f ArgsCompleter) CandidateCompleter {
+	return func(line string, pos int) (string, []Candidate, string) {
+		runes := []rune(line)
+		if pos < 0 || pos > len(runes) {
+			return line, nil, ""
+		}
+		words := shellSplit(runes)
+		start, index := shellWordAt(words, pos)
+		var args []string
+		for _, w := range words {
+			args = append(args, shellUnquote(runes[w.start:w.end]))
+		}
+		if start == pos && (index == len(words) || words[index].start != pos) {
+			// The cursor is not on a word, so it starts a new one
+			args = append(args[:index], append([]string{""}, args[index:]...)...)
+		}
+		quote := shellQuoteOpen(runes[start:pos])
+
+		candidates := f(args, index, shellUnquote(runes[start:pos]))
+		for i, c := range candidates {
+			text, closing := shellQuote(c.Text, quote)
+			if c.Display == "" && text != c.Text {
+				c.Display = c.Text
+			}
+			if c.Suffix == "" || !strings.ContainsAny(c.Suffix[len(c.Suffix)-1:], pathSeparators()) {
+				c.Suffix = closing + c.Suffix
+			}
+			c.Text = text
+			if c.Display == c.Text {
+				c.Display = ""
+			}
+			candidates[i] = c
+		}
+		return string(runes[:start]), candidates, string(runes[pos:])
+	}
+}
+
+func pathSeparators() string {
+	if backslashEscapes {
+		return "/"
+	}
+	return `/\`
+}
+
+// shellWord is the span of runes of a word of a line, with its quotes
+type shellWord struct {
+	start, end int
+}
+
+// shellSplit splits line into words, which are separated by whitespace
+// that is not quoted or escaped.
+func shellSplit(line []rune) []shellWord {
+	var words []shellWord
+	start := -1
+	var quote rune
+	for i := 0; i < len(line); i++ {
+		r := line[i]
+		if start < 0 {
+			if unicode.IsSpace(r) {
+				continue
+			}
+			start = i
+		}
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' && backslashEscapes {
+				i++
+			}
+		case r == '\\' && backslashEscapes:
+			i++
+		case r == '\'' || r == '"':
+			quote = r
+		case unicode.IsSpace(r):
+			words = append(words, shellWord{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, shellWord{start, len(line)})
+	}
+	return words
+}
+
+// shellWordAt returns the start and the index in words of the word that
+// pos is on. If pos is not on a word, it returns pos and the index that a
+// word starting at pos would have.
+func shellWordAt(words []shellWord, pos int) (start, index int) {
+	for i, w := range words {
+		if pos <= w.end {
+			if w.start <= pos {
+				return w.start, i
+			}
+			return pos, i
+		}
+	}
+	return pos, len(words)
+}
+
+// shellQuoteOpen returns the quote that is still open at the end of word,
+// or 0 if there is none
+func shellQuoteOpen(word []rune) rune {
+	var quote rune
+	for i := 0; i < len(word); i++ {
+		r := word[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' && backslashEscapes {
+				i++
+			}
+		case r == '\\' && backslashEscapes:
+			i++
+		case r == '\'' || r == '"':
+			quote = r
+		}
+	}
+	return quote
+}
+
+// shellUnquote returns word with its quotes and escapes removed
+func shellUnquote(word []rune) string {
+	var b strings.Builder
+	var quote rune
+	for i := 0; i < len(word); i++ {
+		r := word[i]
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+			continue
+		case quote == '\'':
+		case r == '\\' && backslashEscapes && i+1 < len(word) &&
+			(quote == 0 || strings.ContainsRune("\\\"$`", word[i+1])):
+			i++
+			r = word[i]
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// shellQuote returns text as a shell word, which starts with quote if it
+// is not 0, and the quote that closes the word, if it needs one. A leading
+// "~/" is left outside any quotes, so that it is still expanded.
+func shellQuote(text string, quote rune) (string, string) {
+	home := ""
+	if strings.HasPrefix(text, "~") && len(text) >= 2 && strings.ContainsRune(pathSeparators(), rune(text[1])) {
+		home, text = text[:2], text[2:]
+	}
+	if quote == 0 && !backslashEscapes && strings.ContainsAny(text, " &()[]{}^=;!'+,`") {
+		quote = '"'
+	}
+	var b strings.Builder
+	b.WriteString(home)
+	switch quote {
+	case '\'':
+		b.WriteRune(quote)
+		b.WriteString(strings.Replace(text, "'", `'\''`, -1))
+	case '"':
+		b.WriteRune(quote)
+		for _, r := range text {
+			if backslashEscapes && strings.ContainsRune("\\\"$`", r) {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+		}
+	default:
+		for _, r := range text {
+			if backslashEscapes && strings.ContainsRune(shellSpecial, r) {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+		}
+		return b.String(), ""
+	}
+	return b.String(), string(quote)
+}
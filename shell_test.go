@@ -0,0 +1,61 @@
+//go:build linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShellWords(t *testing.T) {
+	tests := []struct {
+		line   string
+		pos    int
+		args   []string
+		index  int
+		prefix string
+		head   string
+		text   string
+		suffix string
+		tail   string
+	}{
+		{"git com", 7, []string{"git", "com"}, 1, "com", "git ", "commit", " ", ""},
+		{"git ", 4, []string{"git", ""}, 1, "", "git ", "commit", " ", ""},
+		{"", 0, []string{""}, 0, "", "", "commit", " ", ""},
+		{"git  -v", 4, []string{"git", "", "-v"}, 1, "", "git ", "commit", " ", " -v"},
+		{"git com -v", 5, []string{"git", "com", "-v"}, 1, "c", "git ", "commit", " ", "om -v"},
+		{`cp my\ fi other`, 8, []string{"cp", "my fi", "other"}, 1, "my f", "cp ", `my\ file`, " ", "i other"},
+		{`cp "my fi`, 9, []string{"cp", "my fi"}, 1, "my fi", "cp ", `"my file`, `" `, ""},
+		{`cp 'it'\''s`, 11, []string{"cp", "it's"}, 1, "it's", "cp ", `'it'\''s here`, `' `, ""},
+		{`cp 'it`, 6, []string{"cp", "it"}, 1, "it", "cp ", `'it'\''s here`, `' `, ""},
+		{`cd "my d`, 8, []string{"cd", "my d"}, 1, "my d", "cd ", `"my dir`, "/", ""},
+	}
+	for _, test := range tests {
+		var args []string
+		var index int
+		var prefix string
+		complete := ShellWords(func(a []string, i int, p string) []Candidate {
+			args, index, prefix = a, i, p
+			switch p {
+			case "my d":
+				return []Candidate{{Text: "my dir", Suffix: "/"}}
+			case "my f", "my fi":
+				return []Candidate{{Text: "my file", Suffix: " "}}
+			case "it", "it's":
+				return []Candidate{{Text: "it's here", Suffix: " "}}
+			}
+			return []Candidate{{Text: "commit", Suffix: " "}}
+		})
+		head, candidates, tail := complete(test.line, test.pos)
+		if !reflect.DeepEqual(args, test.args) || index != test.index || prefix != test.prefix {
+			t.Errorf("Splitting %q at %d: expected %q, %d, %q, got %q, %d, %q", test.line, test.pos,
+				test.args, test.index, test.prefix, args, index, prefix)
+		}
+		if len(candidates) != 1 || head != test.head || tail != test.tail ||
+			candidates[0].Text != test.text || candidates[0].Suffix != test.suffix {
+			t.Errorf("Completing %q at %d: expected %q, %q, %q, %q, got %q, %+v, %q", test.line, test.pos,
+				test.head, test.text, test.suffix, test.tail, head, candidates, tail)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBracketedPaste(t *testing.T) {
+	s := newTestSession(t, 80)
+
+	tests := []struct {
+		input, line string
+		keep        bool
+	}{
+		{"\x1b[200~one\r\ntwo\tthree\x1b[201~\r", "one two three", false},
+		{"ab\x1b[200~cd\r\x1b[201~\x1f\r", "ab", false},
+		{"\x1b[200~a\rb\x1b[201~\x02\x02x\r", "ax\nb", true},
+	}
+	for _, test := range tests {
+		s.SetMultiLineMode(test.keep)
+		s.SetPasteNewlines(test.keep)
+		if line := promptWith(t, s, test.input); line != test.line {
+			t.Errorf("Expected %q after %q, got %q", test.line, test.input, line)
+		}
+	}
+	out := s.output.String()
+	if !strings.Contains(out, "\x1b[?2004h") || !strings.HasSuffix(out, "\x1b[?2004l") {
+		t.Errorf("Bracketed paste mode was not turned on and off in %q", out)
+	}
+}
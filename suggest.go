@@ -0,0 +1,93 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"strings"
+	"unicode"
+)
+
+// suggestStyle is the SGR style of the suggested text (dim)
+const suggestStyle = "2"
+
+// suggesting reports whether suggestions are shown
+func (s *State) suggesting() bool {
+	return (s.autoSuggest || s.suggester != nil) && !s.noStyles
+}
+
+// updateSuggestion fetches the suggestion for line, if it has changed since
+// the last call. No suggestion is made in vi normal mode.
+func (s *State) updateSuggestion(line []rune, normal bool) {
+	if !s.suggesting() || normal || len(line) == 0 {
+		s.suggestion, s.suggested = "", false
+		return
+	}
+	str := string(line)
+	if s.suggested && s.suggestedFor == str {
+		return
+	}
+	s.suggestedFor, s.suggested = str, true
+	if s.suggester != nil {
+		s.suggestion = s.suggester(str)
+		return
+	}
+	s.suggestion = ""
+	if s.history == nil {
+		return
+	}
+	s.history.Iterate(false, func(entry HistoryEntry) bool {
+		if h := entry.Line; len(h) > len(str) && strings.HasPrefix(h, str) {
+			s.suggestion = h
+			return false
+		}
+		return true
+	})
+}
+
+// ghost returns the part of the suggestion that is shown after the line, or
+// nil if the suggestion is not shown
+func (s *State) ghost(line []rune, pos int) []rune {
+	if pos != len(line) || len(line) == 0 || len(s.suggestion) <= len(string(line)) ||
+		!strings.HasPrefix(s.suggestion, string(line)) {
+		return nil
+	}
+	return []rune(s.suggestion)[len(line):]
+}
+
+// dropSuggestion stops showing the suggestion, when the line is finished
+// with, and erases it from the screen.
+func (s *State) dropSuggestion(p []rune, line []rune, pos int) error {
+	shown := len(s.ghost(line, pos)) > 0
+	s.suggestion, s.suggested = "", false
+	if shown || s.needRefresh {
+		return s.refresh(p, line, pos)
+	}
+	return nil
+}
+
+// acceptSuggestion handles the keys that accept all or part of a shown
+// suggestion, and reports whether next was one of them.
+func (s *State) acceptSuggestion(next interface{}, line []rune, pos int) ([]rune, int, bool) {
+	ghost := s.ghost(line, pos)
+	if len(ghost) == 0 {
+		return line, pos, false
+	}
+	switch next {
+	case rune(ctrlF), rune(ctrlE), right, end:
+	case altF:
+		// Accept the next word, like Alt-F moves over it
+		n := 0
+		for n < len(ghost) && unicode.IsSpace(ghost[n]) {
+			n++
+		}
+		for n < len(ghost) && !unicode.IsSpace(ghost[n]) {
+			n++
+		}
+		ghost = ghost[:n]
+	default:
+		return line, pos, false
+	}
+	line = append(line, ghost...)
+	return line, len(line), true
+}
@@ -0,0 +1,53 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAutoSuggest(t *testing.T) {
+	s := newTestSession(t, 80)
+	s.SetAutoSuggest(true)
+	s.AppendHistory("git status")
+	s.AppendHistory("go test ./...")
+	s.AppendHistory("git stash")
+
+	tests := []struct {
+		input, line string
+	}{
+		{"git st\x1b[C\r", "git stash"},
+		{"git st\r", "git st"},
+		{"git stat\x05\r", "git status"},
+		{"go\x1bf\x1bf\r", "go test ./..."},
+		{"go\x1bf!\r", "go test!"},
+		{"git\x02\x06\r", "git"},
+	}
+	for _, test := range tests {
+		if line := promptWith(t, s, test.input); line != test.line {
+			t.Errorf("Expected %q after %q, got %q", test.line, test.input, line)
+		}
+	}
+
+	// Wait for the suggestion to be drawn before finishing the line
+	go func() {
+		s.send("git st")
+		s.waitFor("\x1b[2mash\x1b[0m")
+		s.send("\r")
+	}()
+	if line, err := s.Prompt("> "); err != nil || line != "git st" {
+		t.Fatal("Unexpected result from Prompt", line, err)
+	}
+	if out := s.output.String(); !strings.Contains(out, "\x1b[2mash\x1b[0m") {
+		t.Errorf("Suggestion was not shown dimmed in %q", out)
+	}
+
+	s.SetSuggester(func(line string) string {
+		return line + "!"
+	})
+	if line := promptWith(t, s, "hi\x06\r"); line != "hi!" {
+		t.Errorf("Expected %q from Suggester, got %q", "hi!", line)
+	}
+}
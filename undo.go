@@ -0,0 +1,82 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+// editKind classifies a change to the line, so that runs of similar changes
+// can be undone in one step
+type editKind int
+
+const (
+	otherEdit editKind = iota
+	insertEdit
+	historyEdit
+)
+
+type editSnapshot struct {
+	line []rune
+	pos  int
+}
+
+func snapshot(line []rune, pos int) editSnapshot {
+	saved := make([]rune, len(line))
+	copy(saved, line)
+	return editSnapshot{saved, pos}
+}
+
+// editHistory is the undo and redo state of one call to Prompt
+type editHistory struct {
+	prev editSnapshot // the line before the last key was handled
+	run  editKind     // the kind of change that the last key made, if any
+	undo []editSnapshot
+	redo []editSnapshot
+}
+
+// record is called before each key is handled, with the line as the
+// previous key left it and the kind of change that key made. Runs of
+// insertions (or of history navigation) are recorded as a single change.
+func (e *editHistory) record(line []rune, pos int, kind editKind) {
+	if string(line) == string(e.prev.line) {
+		// Any other key, such as a cursor movement, ends a run
+		e.run = otherEdit
+		e.prev.pos = pos
+		return
+	}
+	if kind == otherEdit || kind != e.run {
+		e.undo = append(e.undo, e.prev)
+	}
+	e.redo = nil
+	e.run = kind
+	e.prev = snapshot(line, pos)
+}
+
+// undoLast returns the line as it was before the last change
+func (e *editHistory) undoLast(line []rune, pos int) ([]rune, int, bool) {
+	if len(e.undo) == 0 {
+		return line, pos, false
+	}
+	e.redo = append(e.redo, snapshot(line, pos))
+	last := e.undo[len(e.undo)-1]
+	e.undo = e.undo[:len(e.undo)-1]
+	return e.restore(last)
+}
+
+// redoLast returns the line as it was before the last undo
+func (e *editHistory) redoLast(line []rune, pos int) ([]rune, int, bool) {
+	if len(e.redo) == 0 {
+		return line, pos, false
+	}
+	e.undo = append(e.undo, snapshot(line, pos))
+	last := e.redo[len(e.redo)-1]
+	e.redo = e.redo[:len(e.redo)-1]
+	return e.restore(last)
+}
+
+func (e *editHistory) restore(to editSnapshot) ([]rune, int, bool) {
+	// Undoing and redoing are not changes to be recorded themselves
+	e.prev = snapshot(to.line, to.pos)
+	e.run = otherEdit
+	line := make([]rune, len(to.line))
+	copy(line, to.line)
+	return line, to.pos, true
+}
@@ -0,0 +1,31 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import "testing"
+
+func TestUndo(t *testing.T) {
+	s := newTestSession(t, 80)
+	s.Bind(KeyCtrlO, Redo)
+	s.AppendHistory("first")
+	s.AppendHistory("second")
+
+	tests := []struct {
+		input, line string
+	}{
+		{"hello world\x1f\r", ""},
+		{"hello\x02\x02X\x1f\r", "hello"},
+		{"one two\x17\x1f\r", "one two"},
+		{"one two\x17\x18\x15\r", "one two"},
+		{"abc\x15\x1f\x1f\x0f\r", "abc"},
+		{"abc\x1f\x0f\x0f\r", "abc"},
+		{"x\x15\x10\x10\x1f\x1f\r", "x"},
+		{"ab\x1f\x1fcd\r", "cd"},
+	}
+	for _, test := range tests {
+		if line := promptWith(t, s, test.input); line != test.line {
+			t.Errorf("Expected %q after %q, got %q", test.line, test.input, line)
+		}
+	}
+}
@@ -9,12 +9,7 @@ import (
 )
 
 func (mode *termios) ApplyMode() error {
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(syscall.Stdin), setTermios, uintptr(unsafe.Pointer(mode)))
-
-	if errno != 0 {
-		return errno
-	}
-	return nil
+	return setMode(syscall.Stdin, mode)
 }
 
 // TerminalMode returns the current terminal input mode as an InputModeSetter.
@@ -35,3 +30,12 @@ func getMode(handle int) (*termios, error) {
 
 	return &mode, err
 }
+
+func setMode(handle int, mode *termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(handle), setTermios, uintptr(unsafe.Pointer(mode)))
+
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -5,7 +5,7 @@ import (
 )
 
 func (mode *termios) ApplyMode() error {
-	return unix.IoctlSetTermios(unix.Stdin, setTermios, (*unix.Termios)(mode))
+	return setMode(unix.Stdin, mode)
 }
 
 // TerminalMode returns the current terminal input mode as an InputModeSetter.
@@ -20,3 +20,7 @@ func getMode(handle int) (*termios, error) {
 	tos, err := unix.IoctlGetTermios(handle, getTermios)
 	return (*termios)(tos), err
 }
+
+func setMode(handle int, mode *termios) error {
+	return unix.IoctlSetTermios(handle, setTermios, (*unix.Termios)(mode))
+}
@@ -0,0 +1,420 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import (
+	"unicode"
+)
+
+// viState is the state of vi mode during one call to Prompt
+type viState struct {
+	normal   bool
+	findCmd  rune // the last f, F, t or T command, repeated by ; and ,
+	findChar rune
+}
+
+// viShorthands maps commands to the operator and motion that they stand for
+var viShorthands = map[rune]string{
+	'x': "dl",
+	'X': "dh",
+	's': "cl",
+	'S': "cc",
+	'D': "d$",
+	'C': "c$",
+	'Y': "yy",
+}
+
+// viPrompt returns prompt as it is displayed: preceded by the indicator for
+// the current mode in vi mode, and without styles if the terminal cannot
+// display them
+func (s *State) viPrompt(prompt string, vi *viState) []rune {
+	p := []rune(prompt)
+	if s.editMode == ViMode {
+		// The indicator goes on the row of the edited line
+		header, last := splitPrompt(p)
+		indicator := s.viInsertIndicator
+		if vi.normal {
+			indicator = s.viNormalIndicator
+		}
+		p = append(append(append([]rune{}, header...), []rune(indicator)...), last...)
+	}
+	if s.noStyles {
+		return stripSGR(p)
+	}
+	return p
+}
+
+// escape switches from insert mode to normal mode, and returns the new
+// cursor position
+func (vi *viState) escape(line []rune, pos int) int {
+	vi.normal = true
+	if pos > 0 {
+		pos -= len(getSuffixGlyphs(line[:pos], 1))
+	}
+	return pos
+}
+
+// viClamp keeps the cursor on a character, as normal mode requires
+func viClamp(line []rune, pos int) int {
+	if pos > 0 && pos >= len(line) {
+		pos = len(line) - len(getSuffixGlyphs(line, 1))
+	}
+	return pos
+}
+
+// viClass returns the class of r for word motions: 0 for blanks, 1 for
+// letters, digits and underscores, and 2 for other characters
+func viClass(r rune, bigWord bool) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case bigWord, r == '_', unicode.IsLetter(r), unicode.IsDigit(r):
+		return 1
+	}
+	return 2
+}
+
+// viWordForward returns the start of the next word after pos
+func viWordForward(line []rune, pos int, bigWord bool) int {
+	if pos < len(line) {
+		class := viClass(line[pos], bigWord)
+		for pos < len(line) && class != 0 && viClass(line[pos], bigWord) == class {
+			pos++
+		}
+	}
+	for pos < len(line) && unicode.IsSpace(line[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// viWordBackward returns the start of the word before pos
+func viWordBackward(line []rune, pos int, bigWord bool) int {
+	for pos > 0 && unicode.IsSpace(line[pos-1]) {
+		pos--
+	}
+	if pos > 0 {
+		class := viClass(line[pos-1], bigWord)
+		for pos > 0 && viClass(line[pos-1], bigWord) == class {
+			pos--
+		}
+	}
+	return pos
+}
+
+// viWordEnd returns the end of the word after pos
+func viWordEnd(line []rune, pos int, bigWord bool) int {
+	pos++
+	for pos < len(line) && unicode.IsSpace(line[pos]) {
+		pos++
+	}
+	if pos >= len(line) {
+		return len(line) - 1
+	}
+	class := viClass(line[pos], bigWord)
+	for pos+1 < len(line) && viClass(line[pos+1], bigWord) == class {
+		pos++
+	}
+	return pos
+}
+
+// viFind returns the position that the find command cmd (f, F, t or T)
+// for c moves to from pos, or -1 if c is not found
+func viFind(line []rune, pos int, cmd rune, c rune) int {
+	switch cmd {
+	case 'f', 't':
+		start := pos + 1
+		if cmd == 't' {
+			start++
+		}
+		for i := start; i < len(line); i++ {
+			if line[i] == c {
+				if cmd == 't' {
+					return i - 1
+				}
+				return i
+			}
+		}
+	case 'F', 'T':
+		start := pos - 1
+		if cmd == 'T' {
+			start--
+		}
+		for i := start; i >= 0; i-- {
+			if line[i] == c {
+				if cmd == 'T' {
+					return i + 1
+				}
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// viReverse returns the find command that searches the other way
+func viReverse(cmd rune) rune {
+	switch cmd {
+	case 'f':
+		return 'F'
+	case 'F':
+		return 'f'
+	case 't':
+		return 'T'
+	}
+	return 't'
+}
+
+// viReadRune reads the next key, which must be a printable rune
+func (s *State) viReadRune() (rune, bool, error) {
+	next, err := s.readNext()
+	if err != nil {
+		return 0, false, err
+	}
+	r, ok := next.(rune)
+	if !ok || r < ' ' || r == bs {
+		return 0, false, nil
+	}
+	return r, true, nil
+}
+
+// viCount reads the digits of a count that starts with key, and returns
+// the count (or 1 if there is none) and the key that follows it
+func (s *State) viCount(key rune) (int, rune, bool, error) {
+	count := 0
+	for (key >= '1' && key <= '9') || (count > 0 && key == '0') {
+		count = count*10 + int(key-'0')
+		r, ok, err := s.viReadRune()
+		if !ok || err != nil {
+			return 0, 0, false, err
+		}
+		key = r
+	}
+	if count == 0 {
+		count = 1
+	}
+	return count, key, true, nil
+}
+
+// viMotion returns the position that the motion key, repeated count times,
+// moves the cursor to from pos, and whether the motion includes the
+// character that it lands on. ok is false if key is not a motion or the
+// cursor cannot move.
+func (s *State) viMotion(vi *viState, line []rune, pos int, key rune, count int) (newPos int, inclusive bool, ok bool, err error) {
+	newPos = pos
+	switch key {
+	case 'h', bs:
+		for i := 0; i < count && newPos > 0; i++ {
+			newPos -= len(getSuffixGlyphs(line[:newPos], 1))
+		}
+	case 'l', ' ':
+		for i := 0; i < count && newPos < len(line); i++ {
+			newPos += len(getPrefixGlyphs(line[newPos:], 1))
+		}
+	case '0':
+		newPos = 0
+	case '^':
+		newPos = 0
+		for newPos < len(line)-1 && unicode.IsSpace(line[newPos]) {
+			newPos++
+		}
+	case '$':
+		newPos = viClamp(line, len(line))
+		inclusive = true
+	case 'w', 'W':
+		for i := 0; i < count; i++ {
+			newPos = viWordForward(line, newPos, key == 'W')
+		}
+	case 'b', 'B':
+		for i := 0; i < count; i++ {
+			newPos = viWordBackward(line, newPos, key == 'B')
+		}
+	case 'e', 'E':
+		for i := 0; i < count && newPos < len(line)-1; i++ {
+			newPos = viWordEnd(line, newPos, key == 'E')
+		}
+		inclusive = true
+	case 'f', 'F', 't', 'T', ';', ',':
+		cmd, c := key, rune(0)
+		if key == ';' || key == ',' {
+			if vi.findCmd == 0 {
+				return pos, false, false, nil
+			}
+			cmd, c = vi.findCmd, vi.findChar
+			if key == ',' {
+				cmd = viReverse(cmd)
+			}
+		} else {
+			var ok bool
+			c, ok, err = s.viReadRune()
+			if !ok || err != nil {
+				return pos, false, false, err
+			}
+			vi.findCmd, vi.findChar = key, c
+		}
+		for i := 0; i < count; i++ {
+			found := viFind(line, newPos, cmd, c)
+			if found < 0 {
+				return pos, false, false, nil
+			}
+			newPos = found
+		}
+		inclusive = cmd == 'f' || cmd == 't'
+	default:
+		return pos, false, false, nil
+	}
+	return newPos, inclusive, newPos != pos || key == '0' || key == '^' || key == '$', nil
+}
+
+// viCommand handles key in vi normal mode. It returns the new line and
+// cursor position, and the item (if any) that the rest of the editor should
+// handle instead, such as a history command.
+func (s *State) viCommand(vi *viState, line []rune, pos int, key rune) ([]rune, int, interface{}, error) {
+	count, key, ok, err := s.viCount(key)
+	if !ok || err != nil {
+		return line, pos, nil, err
+	}
+
+	switch key {
+	case 'i':
+		vi.normal = false
+		return line, pos, nil, nil
+	case 'a':
+		vi.normal = false
+		if pos < len(line) {
+			pos += len(getPrefixGlyphs(line[pos:], 1))
+		}
+		return line, pos, nil, nil
+	case 'I':
+		vi.normal = false
+		return line, 0, nil, nil
+	case 'A':
+		vi.normal = false
+		return line, len(line), nil, nil
+	case 'k':
+		return line, pos, rune(ctrlP), nil
+	case 'j':
+		return line, pos, rune(ctrlN), nil
+	case 'u':
+		return line, pos, rune(ctrlUnderscore), nil
+	case 'x', 'X', 's', 'S', 'D', 'C', 'Y':
+		shorthand := viShorthands[key]
+		return s.viOperator(vi, line, pos, rune(shorthand[0]), rune(shorthand[1]), count)
+	case 'd', 'c', 'y':
+		r, ok, err := s.viReadRune()
+		if !ok || err != nil {
+			return line, pos, nil, err
+		}
+		motionCount, motion, ok, err := s.viCount(r)
+		if !ok || err != nil {
+			return line, pos, nil, err
+		}
+		return s.viOperator(vi, line, pos, key, motion, count*motionCount)
+	case 'r':
+		c, ok, err := s.viReadRune()
+		if !ok || err != nil {
+			return line, pos, nil, err
+		}
+		if pos+count > len(line) {
+			s.doBeep()
+			return line, pos, nil, nil
+		}
+		for i := 0; i < count; i++ {
+			line[pos+i] = c
+		}
+		return line, pos + count - 1, nil, nil
+	case '~':
+		if len(line) == 0 {
+			s.doBeep()
+			return line, pos, nil, nil
+		}
+		for i := 0; i < count && pos < len(line); i++ {
+			if unicode.IsUpper(line[pos]) {
+				line[pos] = unicode.ToLower(line[pos])
+			} else {
+				line[pos] = unicode.ToUpper(line[pos])
+			}
+			pos++
+		}
+		return line, viClamp(line, pos), nil, nil
+	case 'p', 'P':
+		if s.killRing == nil {
+			s.doBeep()
+			return line, pos, nil, nil
+		}
+		value := s.killRing.Value.([]rune)
+		if len(value) == 0 {
+			return line, pos, nil, nil
+		}
+		var text []rune
+		for i := 0; i < count; i++ {
+			text = append(text, value...)
+		}
+		if key == 'p' && pos < len(line) {
+			pos += len(getPrefixGlyphs(line[pos:], 1))
+		}
+		line = append(line[:pos], append(text, line[pos:]...)...)
+		// Leave the cursor on the last character put
+		return line, pos + len(text) - len(getSuffixGlyphs(text, 1)), nil, nil
+	}
+
+	newPos, _, ok, err := s.viMotion(vi, line, pos, key, count)
+	if err != nil {
+		return line, pos, nil, err
+	}
+	if !ok {
+		s.doBeep()
+		return line, pos, nil, nil
+	}
+	return line, viClamp(line, newPos), nil, nil
+}
+
+// viOperator applies the operator op (d, c or y) to the text that motion,
+// repeated count times, moves over
+func (s *State) viOperator(vi *viState, line []rune, pos int, op rune, motion rune, count int) ([]rune, int, interface{}, error) {
+	var start, end int
+	if motion == op {
+		// dd, cc and yy apply to the whole line
+		start, end = 0, len(line)
+	} else if op == 'c' && (motion == 'w' || motion == 'W') && pos < len(line) && !unicode.IsSpace(line[pos]) {
+		// cw changes to the end of the word, like ce, even if the
+		// cursor is already there
+		bigWord := motion == 'W'
+		end = pos
+		for end+1 < len(line) && viClass(line[end+1], bigWord) == viClass(line[pos], bigWord) {
+			end++
+		}
+		for i := 1; i < count; i++ {
+			end = viWordEnd(line, end, bigWord)
+		}
+		start, end = pos, end+len(getPrefixGlyphs(line[end:], 1))
+	} else {
+		newPos, inclusive, ok, err := s.viMotion(vi, line, pos, motion, count)
+		if err != nil {
+			return line, pos, nil, err
+		}
+		if !ok || len(line) == 0 {
+			s.doBeep()
+			return line, pos, nil, nil
+		}
+		start, end = pos, newPos
+		if end < start {
+			start, end = end, start
+		}
+		if inclusive && end < len(line) {
+			end += len(getPrefixGlyphs(line[end:], 1))
+		}
+	}
+
+	s.addToKillRing(line[start:end], 0)
+	if op == 'y' {
+		return line, viClamp(line, start), nil, nil
+	}
+	line = append(line[:start], line[end:]...)
+	if op == 'c' {
+		vi.normal = false
+		return line, start, nil, nil
+	}
+	return line, viClamp(line, start), nil, nil
+}
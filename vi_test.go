@@ -0,0 +1,31 @@
+//go:build windows || linux || darwin || openbsd || freebsd || netbsd || solaris
+// +build windows linux darwin openbsd freebsd netbsd solaris
+
+package liner
+
+import "testing"
+
+func TestViMode(t *testing.T) {
+	s := newTestSession(t, 80)
+	s.SetEditMode(ViMode)
+	s.AppendHistory("previous")
+
+	tests := []struct {
+		input, line string
+	}{
+		{"hello world\x1b0dwxuuA!\x1b\r", "hello world!"},
+		{"foo bar\x1b0cwbaz\x1b\r", "baz bar"},
+		{"a-b-c\x1b0f-;x\r", "a-bc"},
+		{"abc\x1b0xp\r", "bac"},
+		{"one two three\x1b02dw\r", "three"},
+		{"abc def\x1b0wD\r", "abc "},
+		{"abc def\x1b0ywP\r", "abc abc def"},
+		{"abcd\x1b02r-l~\r", "--Cd"},
+		{"\x1bk\r", "previous"},
+	}
+	for _, test := range tests {
+		if line := promptWith(t, s, test.input); line != test.line {
+			t.Errorf("Expected %q after %q, got %q", test.line, test.input, line)
+		}
+	}
+}
@@ -17,11 +17,50 @@ var zeroWidth = []*unicode.RangeTable{
 	unicode.Cf,
 }
 
-// countGlyphs considers zero-width characters to be zero glyphs wide,
-// and members of Chinese, Japanese, and Korean scripts to be 2 glyphs wide.
+// sgrLen returns the length of the SGR (Select Graphic Rendition) escape
+// sequence, such as "\x1b[1;31m", at the start of s, or 0 if s does not
+// start with one
+func sgrLen(s []rune) int {
+	if len(s) < 3 || s[0] != esc || s[1] != '[' {
+		return 0
+	}
+	for i := 2; i < len(s); i++ {
+		switch {
+		case s[i] == 'm':
+			return i + 1
+		case s[i] != ';' && (s[i] < '0' || s[i] > '9'):
+			return 0
+		}
+	}
+	return 0
+}
+
+// stripSGR returns s without its SGR escape sequences
+func stripSGR(s []rune) []rune {
+	stripped := make([]rune, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if n := sgrLen(s[i:]); n > 0 {
+			i += n - 1
+			continue
+		}
+		stripped = append(stripped, s[i])
+	}
+	return stripped
+}
+
+// countGlyphs considers zero-width characters and SGR escape sequences to be
+// zero glyphs wide, and members of Chinese, Japanese, and Korean scripts to
+// be 2 glyphs wide.
 func countGlyphs(s []rune) int {
 	n := 0
-	for _, r := range s {
+	for i := 0; i < len(s); i++ {
+		r := s[i]
+		if r == esc {
+			if l := sgrLen(s[i:]); l > 0 {
+				i += l - 1
+				continue
+			}
+		}
 		// speed up the common case
 		if r < 127 {
 			n++
@@ -34,16 +73,41 @@ func countGlyphs(s []rune) int {
 }
 
 func countMultiLineGlyphs(s []rune, columns int, start int) int {
-	n := start
-	for _, r := range s {
+	n, _ := multiLineGlyphs(s, columns, start)
+	return n
+}
+
+// multiLineGlyphs is countMultiLineGlyphs, and also reports whether the
+// last glyph filled its row, which leaves the terminal waiting to wrap.
+// A newline moves to the start of the next row.
+func multiLineGlyphs(s []rune, columns int, start int) (n int, full bool) {
+	n = start
+	full = n > 0 && n%columns == 0
+	for i := 0; i < len(s); i++ {
+		r := s[i]
+		if r == esc {
+			if l := sgrLen(s[i:]); l > 0 {
+				i += l - 1
+				continue
+			}
+		}
+		if r == '\n' {
+			if !full {
+				n += columns - n%columns
+			}
+			full = false
+			continue
+		}
 		if r < 127 {
 			n++
+			full = n%columns == 0
 			continue
 		}
 		switch runewidth.RuneWidth(r) {
 		case 0:
 		case 1:
 			n++
+			full = n%columns == 0
 		case 2:
 			n += 2
 			// no room for a 2-glyphs-wide char in the ending
@@ -51,9 +115,10 @@ func countMultiLineGlyphs(s []rune, columns int, start int) int {
 			if n%columns == 1 {
 				n++
 			}
+			full = n%columns == 0
 		}
 	}
-	return n
+	return n, full
 }
 
 func getPrefixGlyphs(s []rune, num int) []rune {
@@ -74,6 +139,29 @@ func getPrefixGlyphs(s []rune, num int) []rune {
 	return s[:p]
 }
 
+// getPrefixColumns returns the longest prefix of s that is at most columns
+// wide, counting glyphs as countGlyphs does.
+func getPrefixColumns(s []rune, columns int) []rune {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == esc {
+			if l := sgrLen(s[i:]); l > 0 {
+				i += l - 1
+				continue
+			}
+		}
+		w := 1
+		if s[i] >= 127 {
+			w = runewidth.RuneWidth(s[i])
+		}
+		if n+w > columns {
+			return s[:i]
+		}
+		n += w
+	}
+	return s
+}
+
 func getSuffixGlyphs(s []rune, num int) []rune {
 	p := len(s)
 	for n := 0; n < num && p > 0; p-- {
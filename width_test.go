@@ -76,6 +76,29 @@ func TestPrefixGlyphs(t *testing.T) {
 	}
 }
 
+func TestPrefixColumns(t *testing.T) {
+	tests := []struct {
+		s       string
+		columns int
+		prefix  string
+	}{
+		{"query", 3, "que"},
+		{"私は", 3, "私"},
+		{"私は", 4, "私は"},
+		{"hello『世界』", 8, "hello『"},
+		{"\x1b[1m私\x1b[0mは", 2, "\x1b[1m私\x1b[0m"},
+		{"query", -3, ""},
+	}
+	for _, test := range tests {
+		out := getPrefixColumns([]rune(test.s), test.columns)
+		compare(out, []rune(test.prefix), "column prefix "+strconv.Itoa(test.columns), t)
+		out = getPrefixColumns(accent([]rune(test.s)), test.columns)
+		if n := countGlyphs(out); n > test.columns && test.columns >= 0 {
+			t.Errorf("getPrefixColumns(accent(%q), %d) is %d columns wide", test.s, test.columns, n)
+		}
+	}
+}
+
 func TestSuffixGlyphs(t *testing.T) {
 	for _, testCase := range testCases {
 		for i := 0; i <= len(testCase.s); i++ {
@@ -100,3 +123,38 @@ func TestSuffixGlyphs(t *testing.T) {
 		}
 	}
 }
+
+func TestMultiLineGlyphs(t *testing.T) {
+	tests := []struct {
+		s     string
+		start int
+		n     int
+		full  bool
+	}{
+		{"abc", 0, 3, false},
+		{"abcd", 0, 4, true},
+		{"ab\ncd", 0, 6, false},
+		{"abcd\nef", 0, 6, false},
+		{"ab\n", 0, 4, false},
+		{"\n", 4, 4, false},
+		{"\n\n", 0, 8, false},
+	}
+	for _, test := range tests {
+		n, full := multiLineGlyphs([]rune(test.s), 4, test.start)
+		if n != test.n || full != test.full {
+			t.Errorf("multiLineGlyphs(%q, 4, %d) = %d, %t, expected %d, %t",
+				test.s, test.start, n, full, test.n, test.full)
+		}
+	}
+}
+
+func TestSGR(t *testing.T) {
+	styled := []rune("\x1b[1;31m私\x1b[0m> \x1b[")
+	if n := countGlyphs(styled); n != 6 {
+		t.Errorf("countGlyphs(%q) = %d, expected 6", string(styled), n)
+	}
+	if n := countMultiLineGlyphs(styled, 80, 0); n != 6 {
+		t.Errorf("countMultiLineGlyphs(%q) = %d, expected 6", string(styled), n)
+	}
+	compare(stripSGR(styled), []rune("私> \x1b["), "stripSGR", t)
+}